@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"net"
+	"os"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -18,22 +22,19 @@ type Bot struct {
 	Config     *config.Configuration
 	Connection *irc.Connection
 	modules    map[string]*modules.Module
+	order      []*modules.Module //modules sorted by dependencies
 	mwg        *sync.WaitGroup
+	mlock      sync.Mutex //serializes enabling and disabling of modules
+	reloadLock sync.Mutex //serializes config reloads
 	restarting bool
 }
 
-var generateConfig = flag.Bool("config", false, "Generate empty config if not exists?")
+var generateConfig = flag.Bool("generate", false, "Generate empty config if not exists?")
+var configFile = flag.String("config", "", "Config file (.json, .yaml or .toml), config.json next to bot if empty")
+var server = flag.String("server", "", "Server as host[:port], overrides config")
+var nick = flag.String("nick", "", "Nick, overrides config")
 var debug = flag.Bool("debug", false, "Print debug messages?")
 
-func init() {
-	flag.Parse()
-
-	log.SetFormatter(&PrettyFormatter{})
-	if *debug == true {
-		log.SetLevel(log.DebugLevel)
-	}
-}
-
 // NewBot create's new Bot instance
 func NewBot() *Bot {
 	return &Bot{Config: config.NewConfiguration(), Connection: irc.NewConnection("dashy", "grainbot", "Botus Grainus"), modules: make(map[string]*modules.Module), mwg: &sync.WaitGroup{}}
@@ -69,7 +70,7 @@ func (b *Bot) Run() {
 		log.Infof("GRAINBOT - GRAIN based IRC bot ( pid: %d )", Getpid())
 
 		//load config
-		err = b.Config.Load()
+		err = b.Config.LoadFromFile(*configFile)
 		if err != nil {
 			if !*generateConfig {
 				log.Fatal(err)
@@ -77,22 +78,54 @@ func (b *Bot) Run() {
 			}
 
 			log.Info("Generating example config.")
-			b.Config.LoadExampleConfig()
+			if err := b.Config.LoadExampleConfigTo(*configFile); err != nil {
+				log.Fatal(err)
+				return
+			}
+		}
+
+		//environment and flags override config file, they are never saved
+		if err := b.Config.OverrideFromEnv(os.Environ()); err != nil {
+			log.Error(err)
+		}
+		if err := overrideFromFlags(b.Config); err != nil {
+			log.Fatal(err)
+			return
 		}
 		log.Info("Config loaded.")
 
 		//Start module thingie
+		modules.SetManager(b)
 		modules.Start(b.Connection, b.Config)
 
+		//sort modules by dependencies
+		var list []*modules.Module
+		for _, mod := range b.modules {
+			if mod != nil {
+				list = append(list, mod)
+			}
+		}
+		b.order, err = modules.SortModules(list)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+
 		//load modules
 		log.Debug("Loading modules...")
-		for modname, mod := range b.modules {
-			if mod != nil {
-				b.mwg.Add(1)
-				mod.Activate()
-				log.Debug("Module \"" + modname + "\" loaded.")
+		b.mlock.Lock()
+		for _, mod := range b.order {
+			if moduleDisabled(b.Config, mod.Name()) {
+				log.Debug("Module \"" + mod.Name() + "\" is disabled.")
+				continue
+			}
+			if err := b.checkDependencies(mod); err != nil {
+				log.Warn(err)
+				continue
 			}
+			b.activateModule(mod)
 		}
+		b.mlock.Unlock()
 		log.Info("Modules loaded.")
 	}()
 
@@ -106,21 +139,26 @@ func (b *Bot) Run() {
 		//unload modules
 		if !b.restarting {
 			log.Debug("Unloading modules...")
-			for modname, mod := range b.modules {
-				if mod != nil {
-					mod.Deactivate()
-					b.mwg.Done()
-					log.Debug("Module \"" + modname + "\" unloaded.")
-				}
+			b.mlock.Lock()
+			for i := len(b.order) - 1; i >= 0; i-- {
+				b.deactivateModule(b.order[i])
 			}
+			b.mlock.Unlock()
 			b.mwg.Wait() //wait for closing of all
 			log.Info("Modules unloaded.")
 		}
 
+		//close module stores, modules are unloaded (or store was closed before fork)
+		if err := modules.CloseStore(); err != nil {
+			log.Error(err)
+		}
+
 		//save config
 		if !b.restarting {
 			err = b.Config.Save()
-			if err != nil {
+			if err == config.ErrConfigModified {
+				log.Warn("Config file was changed on disk, keeping it.")
+			} else if err != nil {
 				log.Fatalf("Config save failed. %s", err)
 			} else {
 				log.Info("Config saved.")
@@ -141,6 +179,15 @@ func (b *Bot) Run() {
 		b.Connection.Port = 6667
 	}
 	b.Connection.Secured = b.Config.SSL
+	b.Connection.TLS = irc.TLSOptions{
+		CertFile:    b.Config.TLSCert,
+		KeyFile:     b.Config.TLSKey,
+		CAFile:      b.Config.TLSCA,
+		Fingerprint: b.Config.TLSFingerprint,
+		ServerName:  b.Config.TLSServerName,
+		Insecure:    b.Config.TLSInsecure,
+		MinVersion:  b.Config.TLSMinVersion,
+	}
 
 	if b.Config.Nick != "" {
 		b.Connection.Nickname = b.Config.Nick
@@ -154,18 +201,40 @@ func (b *Bot) Run() {
 		b.Connection.RealName = b.Config.RealName
 	}
 
+	b.Connection.MaxLines = b.Config.MaxLines
+
+	if b.Config.SASLMechanism != "" {
+		mech, err := irc.NewSASLMechanism(b.Config.SASLMechanism, b.Config.SASLUser, b.Config.SASLPassword)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		b.Connection.SASL = mech
+		b.Connection.SASLAbort = b.Config.SASLAbort
+	}
+
+	//tell modules when server accepts us
+	b.Connection.AddHandler(func(event *irc.Message) {
+		if event.Command == "001" {
+			b.runHook(modules.HookRegistered)
+		}
+	}, nil)
+
 	//connect
 	if socket != nil {
 		if err := b.Connection.ConnectTo(socket); err != nil {
 			log.Fatal(err)
 			return
 		}
+		b.runHook(modules.HookConnect)
+		b.runHook(modules.HookRegistered) //connection after restart is registered already
 	} else {
 		//else make new connection
 		if err := b.Connection.Connect(); err != nil {
 			log.Fatal(err)
 			return
 		}
+		b.runHook(modules.HookConnect)
 	}
 
 	//reconnect on error
@@ -175,17 +244,33 @@ func (b *Bot) Run() {
 			if !b.Connection.IsConnected {
 				return
 			}
+			if err == irc.ErrSASLFailed {
+				log.Error("SASL authentication failed, shutting down.")
+				syscall.Kill(Getpid(), SIGQUIT)
+				return
+			}
 			log.Errorf("error: %s", err)
+			b.runHook(modules.HookDisconnect)
 			log.Info("Reconnecting in 10 seconds...")
 			time.Sleep(10 * time.Second)
 
 			err = b.Connection.Reconnect()
-			if err != nil {
+			if err == nil {
+				b.runHook(modules.HookConnect)
+			} else {
 				log.Errorf("error: %s", err)
+				if _, ok := err.(*irc.FingerprintError); ok {
+					log.Error("Refusing to talk with server with unexpected certificate, shutting down.")
+					syscall.Kill(Getpid(), SIGQUIT)
+					return
+				}
 			}
 		}
 	}()
 
+	//reload config on its change
+	go b.watchConfig()
+
 	//cekej na signal k ukonceni
 	if err := b.WaitOnSignals(b.Connection.Socket); err != nil {
 		log.Fatal(err)
@@ -198,25 +283,77 @@ func (b *Bot) Run() {
 	if err := b.Connection.Disconnect(); err != nil {
 		log.Fatal(err)
 	}
+	if !b.restarting {
+		b.runHook(modules.HookDisconnect)
+	}
+}
+
+// overrideFromFlags applies -server and -nick over config
+func overrideFromFlags(conf *config.Configuration) error {
+	if *server != "" {
+		host, port := *server, ""
+		if h, p, err := net.SplitHostPort(*server); err == nil {
+			host, port = h, p
+		}
+		if err := conf.Override("HostName", host); err != nil {
+			return err
+		}
+		if port != "" {
+			if err := conf.Override("Port", port); err != nil {
+				return errors.New("Invalid port of server! " + err.Error())
+			}
+		}
+	}
+	if *nick != "" {
+		return conf.Override("Nick", *nick)
+	}
+	return nil
+}
+
+// runHook call's hook of active modules in order of dependencies (reversed for disconnect and restart)
+func (b *Bot) runHook(h modules.Hook) {
+	b.mlock.Lock()
+	order := make([]*modules.Module, len(b.order))
+	copy(order, b.order)
+	b.mlock.Unlock()
+
+	log.Debugf("Running %s hooks.", h)
+	for i := range order {
+		if h.Reverse() {
+			order[len(order)-1-i].RunHook(h)
+		} else {
+			order[i].RunHook(h)
+		}
+	}
 }
 
 func (b *Bot) beforeFork() error {
 	log.Infof("GRAINBOT ( pid: %d ) RESTARTING", Getpid())
 
+	//modules can still talk to server and use their store
+	b.runHook(modules.HookBeforeRestart)
+
 	b.restarting = true
 	b.Connection.Restart()
 
-	for _, module := range b.modules {
-		if module != nil {
-			module.Deactivate()
-			b.mwg.Done()
-		}
+	b.mlock.Lock()
+	for i := len(b.order) - 1; i >= 0; i-- {
+		b.deactivateModule(b.order[i])
 	}
+	b.mlock.Unlock()
 	b.mwg.Wait() //wait for closing of all
 
-	//save config right now
+	//release database lock for child
+	if err := modules.CloseStore(); err != nil {
+		log.Error(err)
+	}
+
+	//save config right now, changed file is loaded by child
 	err := b.Config.Save()
-	if err != nil {
+	if err == config.ErrConfigModified {
+		log.Warn("Config file was changed on disk, keeping it.")
+		return nil
+	} else if err != nil {
 		log.Fatalf("Config save failed. %s", err)
 	}
 
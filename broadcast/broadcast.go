@@ -1,33 +1,183 @@
 package broadcast
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type Message interface{}
 type MessageChannel chan Message
 
+// Policy tells what to do when listener buffer is full
+type Policy int
+
+const (
+	Block      Policy = iota // wait for listener (blocks all other listeners)
+	DropOldest               // throw away oldest buffered message
+	DropNewest               // throw away the new message
+	Disconnect               // close and remove the listener
+)
+
+type listener struct {
+	dropped uint64 //first for 64-bit atomic alignment
+	c       MessageChannel
+	policy  Policy
+
+	quit     chan struct{} //closed by Unlisten, wakes up blocked send
+	quitOnce sync.Once
+}
+
 type Broadcaster struct {
 	inc       chan Message
-	registryc chan MessageChannel
-	listeners []MessageChannel
+	registryc chan *listener
+	unregc    chan MessageChannel
+	done      chan struct{}
+	listeners []*listener
+
+	closeOnce sync.Once
+	lock      sync.RWMutex
+	byChannel map[MessageChannel]*listener
 }
 
 func (b *Broadcaster) Write(v Message) {
-	b.inc <- v
+	select {
+	case b.inc <- v:
+	case <-b.done:
+	}
 }
 
+// Listen register's new listener which blocks broadcasting when its buffer is full
 func (b *Broadcaster) Listen(bufferSize int) chan Message {
+	return b.ListenWithPolicy(bufferSize, Block)
+}
+
+// ListenWithPolicy register's new listener with overflow policy
+func (b *Broadcaster) ListenWithPolicy(bufferSize int, policy Policy) chan Message {
 	c := make(chan Message, bufferSize)
-	b.registryc <- c
+	l := &listener{c: c, policy: policy, quit: make(chan struct{})}
+
+	b.lock.Lock()
+	b.byChannel[c] = l
+	b.lock.Unlock()
+
+	select {
+	case b.registryc <- l:
+	case <-b.done:
+		close(c)
+	}
 	return c
 }
 
+// Unlisten remove's listener and closes its channel, listener can call it even while broadcast waits for it (Block)
+func (b *Broadcaster) Unlisten(c chan Message) {
+	b.lock.RLock()
+	l, ok := b.byChannel[c]
+	b.lock.RUnlock()
+	if ok {
+		l.quitOnce.Do(func() {
+			close(l.quit)
+		})
+	}
+
+	select {
+	case b.unregc <- c:
+	case <-b.done:
+	}
+}
+
+// Dropped return's count of messages dropped for listener (kept for disconnected listeners until Unlisten)
+func (b *Broadcaster) Dropped(c chan Message) uint64 {
+	b.lock.RLock()
+	l, ok := b.byChannel[c]
+	b.lock.RUnlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close stop's broadcasting and closes all listener channels
+func (b *Broadcaster) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+func (b *Broadcaster) send(l *listener, v Message) bool {
+	switch l.policy {
+	case DropOldest:
+		for {
+			select {
+			case l.c <- v:
+				return true
+			default:
+			}
+			select {
+			case <-l.c:
+				atomic.AddUint64(&l.dropped, 1)
+			default:
+			}
+		}
+	case DropNewest:
+		select {
+		case l.c <- v:
+		default:
+			atomic.AddUint64(&l.dropped, 1)
+		}
+	case Disconnect:
+		select {
+		case l.c <- v:
+		default:
+			atomic.AddUint64(&l.dropped, 1)
+			return false
+		}
+	default:
+		select {
+		case l.c <- v:
+		case <-l.quit: //leaving listener does not get it
+		case <-b.done:
+		}
+	}
+	return true
+}
+
+func (b *Broadcaster) remove(c MessageChannel) {
+	for i, l := range b.listeners {
+		if l.c == c {
+			b.listeners = append(b.listeners[:i], b.listeners[i+1:]...)
+			close(c)
+			return
+		}
+	}
+}
+
 func (b *Broadcaster) loop() {
 	for {
 		select {
 		case v := <-b.inc:
-			for _, c := range b.listeners {
-				c <- v
+			var slow []MessageChannel
+			for _, l := range b.listeners {
+				if !b.send(l, v) {
+					slow = append(slow, l.c)
+				}
+			}
+			for _, c := range slow {
+				b.remove(c)
+			}
+		case l := <-b.registryc:
+			b.listeners = append(b.listeners, l)
+		case c := <-b.unregc:
+			b.remove(c)
+
+			b.lock.Lock()
+			delete(b.byChannel, c)
+			b.lock.Unlock()
+		case <-b.done:
+			for _, l := range b.listeners {
+				close(l.c)
 			}
-		case c := <-b.registryc:
-			b.listeners = append(b.listeners, c)
+			b.listeners = nil
+			return
 		}
 	}
 }
@@ -35,7 +185,10 @@ func (b *Broadcaster) loop() {
 func NewBroadcaster(bufferSize int) *Broadcaster {
 	b := &Broadcaster{
 		inc:       make(chan Message, bufferSize),
-		registryc: make(chan MessageChannel),
+		registryc: make(chan *listener),
+		unregc:    make(chan MessageChannel),
+		done:      make(chan struct{}),
+		byChannel: make(map[MessageChannel]*listener),
 	}
 	go b.loop()
 	return b
@@ -57,3 +57,116 @@ func TestBroadcaster(t *testing.T) {
 		t.Fatalf("Messages were not received by listeners.")
 	}
 }
+
+func TestUnlisten(t *testing.T) {
+	b := NewBroadcaster(1024)
+	defer b.Close()
+
+	stuck := b.Listen(1)
+	b.Unlisten(stuck)
+
+	if _, ok := <-stuck; ok {
+		t.Fatal("Listener channel not closed!")
+	}
+
+	// nobody reads "stuck" anymore so this would block if it was still registered
+	active := b.Listen(10)
+	for x := 0; x < 5; x++ {
+		b.Write(x)
+	}
+
+	for x := 0; x < 5; x++ {
+		select {
+		case <-active:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Broadcaster blocked by removed listener.")
+		}
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	b := NewBroadcaster(1024)
+	defer b.Close()
+
+	oldest := b.ListenWithPolicy(2, DropOldest)
+	newest := b.ListenWithPolicy(2, DropNewest)
+	disconnect := b.ListenWithPolicy(2, Disconnect)
+	sync := b.Listen(10)
+
+	for x := 1; x <= 4; x++ {
+		b.Write(x)
+	}
+	for x := 1; x <= 4; x++ {
+		<-sync //all messages passed the loop
+	}
+
+	if a, b := <-oldest, <-oldest; a != 3 || b != 4 {
+		t.Errorf("DropOldest kept %v, %v", a, b)
+	}
+	if a, b := <-newest, <-newest; a != 1 || b != 2 {
+		t.Errorf("DropNewest kept %v, %v", a, b)
+	}
+	if b.Dropped(oldest) != 2 || b.Dropped(newest) != 2 || b.Dropped(disconnect) != 1 {
+		t.Errorf("Wrong drop counters: %d %d %d", b.Dropped(oldest), b.Dropped(newest), b.Dropped(disconnect))
+	}
+
+	<-disconnect
+	<-disconnect
+	if _, ok := <-disconnect; ok {
+		t.Error("Slow listener not disconnected!")
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroadcaster(1024)
+	l := b.Listen(1)
+
+	b.Close()
+	b.Write(1) // must not block or panic
+
+	select {
+	case _, ok := <-l:
+		if ok {
+			t.Error("Got message after close")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Listener not closed")
+	}
+}
+
+func TestUnlistenSelf(t *testing.T) {
+	b := NewBroadcaster(1024)
+	defer b.Close()
+	l := b.Listen(1)
+	other := b.Listen(10)
+
+	b.Write(1)
+	b.Write(2)
+	if v := <-other; v != 1 {
+		t.Fatal("Wrong first message: ", v)
+	}
+	time.Sleep(50 * time.Millisecond) //broadcast waits for l with 2
+
+	done := make(chan bool)
+	go func() {
+		b.Unlisten(l) //from listener itself while broadcast waits for it
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Unlisten of blocking listener deadlocked")
+	}
+
+	b.Write(3)
+	for _, want := range []int{2, 3} {
+		select {
+		case v := <-other:
+			if v != want {
+				t.Errorf("Got %v, expected %d", v, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("Broadcast stuck after Unlisten")
+		}
+	}
+}
@@ -11,25 +11,92 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Configuration struct {
 	filepath string
+	modTime  time.Time //of config file when loaded or saved
+
+	overrides  []override           //from environment and command line, in order of applying
+	fileValues map[string]fileValue //file values of overridden keys
+
 	HostName string
 	Port     int
 	SSL      bool
+
+	TLSCert        string //client certificate for CertFP or SASL EXTERNAL
+	TLSKey         string
+	TLSCA          string //CA bundle for self-signed networks
+	TLSFingerprint string //SHA-256 fingerprint of server certificate to pin
+	TLSServerName  string
+	TLSInsecure    bool   //skip certificate verification, for testing only!
+	TLSMinVersion  string //1.0, 1.1, 1.2 or 1.3
+
 	Nick     string
 	UserName string
 	RealName string
 
-	Owner     string
+	SASLMechanism string //PLAIN, EXTERNAL or SCRAM-SHA-256, empty to disable
+	SASLUser      string
+	SASLPassword  string
+	SASLAbort     bool //disconnect on SASL failure instead of continuing unauthenticated
+
+	Owner     StringList //nick!user@host masks of owners
 	UpdateUrl string
 
+	WatchConfig   bool //reload config when its file changes
+	ConfigBackups int  //backups kept on save (config.json.1 is newest), 0 for 3, -1 for none
+
+	MaxLines int    //max lines of one reply, 0 for unlimited
+	ACLFile  string //json file with role grants, relative to config directory
+
+	IgnoreFile string //json file with ignore list, relative to config directory
+
+	StoreFile string //database of module stores, relative to config directory
+
+	CommandPrefixes        StringList            //command triggers, "." if empty
+	ChannelCommandPrefixes map[string]StringList //command triggers overriding CommandPrefixes in channel
+	CommandOnNick          bool                  //accept also "botnick: command", module responses react to it too
+
+	DisabledModules        StringList            //modules not activated on start
+	ChannelDisabledModules map[string]StringList //channel -> modules not reacting in it
+
+	UserRate       int    //commands and responses per minute for one user, 0 for unlimited
+	SlowDownNotice string //notice sent once to rate limited user, {nick} is replaced by nick
+
 	Modules map[string]interface{}
 
 	sync.RWMutex
 }
 
+// StringList is list of strings which can be loaded also from single string
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = nil
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*l = nil
+		} else {
+			*l = StringList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = StringList(list)
+	return nil
+}
+
 func NewConfiguration() *Configuration {
 	return &Configuration{}
 }
@@ -43,31 +110,20 @@ func (conf *Configuration) LoadFromFile(file string) error {
 	defer conf.Unlock()
 
 	if file != "" {
-		if filepath.IsAbs(file) {
-			pathToConfig = file
-		} else {
-			var filename string
-			filename, err = filepath.Abs(file)
-			if err == nil {
-				if _, err := os.Stat(filename); !os.IsNotExist(err) {
-					pathToConfig = filename
-				}
-			}
+		filename := resolvePath(file)
+		if _, err := os.Stat(filename); filepath.IsAbs(file) || !os.IsNotExist(err) {
+			pathToConfig = filename
 		}
-	}
-
-	if pathToConfig == "" {
+	} else {
 		var path string
 		path, err = osext.ExecutableFolder() //current bin directory
 		if err == nil {
-			var filename string
-			if file == "" {
-				filename = filepath.Join(path, "config.json")
-			} else {
-				filename = filepath.Join(path, file)
-			}
-			if _, err := os.Stat(filename); !os.IsNotExist(err) {
-				pathToConfig = filename
+			for _, name := range configNames { //first existing of json, yaml or toml
+				filename := filepath.Join(path, name)
+				if _, err := os.Stat(filename); !os.IsNotExist(err) {
+					pathToConfig = filename
+					break
+				}
 			}
 		}
 	}
@@ -77,10 +133,11 @@ func (conf *Configuration) LoadFromFile(file string) error {
 		buff, err = ioutil.ReadFile(pathToConfig)
 
 		if err == nil {
-			err = json.Unmarshal(buff, conf)
+			err = decode(formatOf(pathToConfig), buff, conf)
 			if err == nil {
 				loaded = true
 				conf.filepath = pathToConfig
+				conf.modTime = fileModTime(pathToConfig)
 			}
 		}
 	}
@@ -96,10 +153,41 @@ func (conf *Configuration) LoadFromFile(file string) error {
 	return nil
 }
 
+// resolvePath return's path of config file, relative one is in current directory if it exists there
+// and next to the bot binary otherwise
+func resolvePath(file string) string {
+	if filepath.IsAbs(file) {
+		return filepath.Clean(file)
+	}
+	if filename, err := filepath.Abs(file); err == nil {
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			return filename
+		}
+	}
+	if path, err := osext.ExecutableFolder(); err == nil {
+		return filepath.Join(path, file)
+	}
+	return file
+}
+
 func (conf *Configuration) Load() error {
 	return conf.LoadFromFile("")
 }
 
+// Dir return's directory of loaded config file or of the bot binary
+func (conf *Configuration) Dir() string {
+	conf.RLock()
+	defer conf.RUnlock()
+
+	if conf.filepath != "" {
+		return filepath.Dir(conf.filepath)
+	}
+	if path, err := osext.ExecutableFolder(); err == nil {
+		return path
+	}
+	return "."
+}
+
 func LoadConfigFromFile(file string) (*Configuration, error) {
 	conf := &Configuration{}
 	return conf, conf.LoadFromFile(file)
@@ -111,6 +199,15 @@ func LoadConfig() (*Configuration, error) {
 }
 
 func (conf *Configuration) SaveToFile(file string) error {
+	return conf.saveToFile(file, false)
+}
+
+// ForceSaveToFile save's config even if its file was changed on disk since it was loaded
+func (conf *Configuration) ForceSaveToFile(file string) error {
+	return conf.saveToFile(file, true)
+}
+
+func (conf *Configuration) saveToFile(file string, force bool) error {
 	if conf == nil {
 		return errors.New("I need valid Configuration to save!")
 	}
@@ -133,21 +230,23 @@ func (conf *Configuration) SaveToFile(file string) error {
 				filename = "config.json"
 			}
 		}
-	} else if !filepath.IsAbs(file) {
-		var path string
-		path, err = osext.ExecutableFolder() //current bin directory
-		if err == nil {
-			filename = filepath.Join(path, file)
-		} else {
-			filename = file
-		}
+	} else {
+		filename = resolvePath(file)
+	}
+
+	if filename == conf.filepath && !force && conf.changedOnDisk() {
+		return ErrConfigModified
 	}
 
 	if filename != "" {
 		var cbuf []byte
-		cbuf, err = json.MarshalIndent(conf, "", "    ")
+		layer := conf.fileLayer() //without overrides
+		cbuf, err = encode(formatOf(filename), layer)
 		if err == nil {
-			err = ioutil.WriteFile(filename, cbuf, 0644)
+			err = WriteFile(filename, cbuf, layer.fileMode(filename), conf.backups())
+		}
+		if err == nil && filename == conf.filepath {
+			conf.modTime = fileModTime(filename)
 		}
 	}
 
@@ -166,6 +265,11 @@ func (conf *Configuration) Save() error {
 	return conf.SaveToFile("")
 }
 
+// ForceSave save's config to file it was loaded from, even if the file was changed on disk
+func (conf *Configuration) ForceSave() error {
+	return conf.ForceSaveToFile("")
+}
+
 func SaveConfig(conf *Configuration) error {
 	return conf.SaveToFile("")
 }
@@ -222,37 +326,17 @@ func (conf *Configuration) Get(key string) (interface{}, error) {
 
 func (conf *Configuration) GetString(key string) string {
 	ret, _ := conf.Get(key)
-
-	switch s := ret.(type) {
-	case string:
-		return s
-	case float64:
-		return strconv.FormatFloat(ret.(float64), 'f', -1, 64)
-	case int:
-		return strconv.FormatInt(int64(ret.(int)), 10)
-	case []byte:
-		return string(s)
-	case nil:
-		return ""
-	default:
-		return ""
-	}
+	return toString(ret)
 }
 
 func (conf *Configuration) GetInt(key string) int {
 	ret, _ := conf.Get(key)
 
+	if n, ok := toInt(ret); ok {
+		return int(n)
+	}
+
 	switch s := ret.(type) {
-	case int:
-		return s
-	case int64:
-		return int(s)
-	case int32:
-		return int(s)
-	case int16:
-		return int(s)
-	case int8:
-		return int(s)
 	case string:
 		v, err := strconv.ParseInt(s, 0, 0)
 		if err == nil {
@@ -260,16 +344,12 @@ func (conf *Configuration) GetInt(key string) int {
 		} else {
 			return 0
 		}
-	case float64:
-		return int(s)
 	case bool:
 		if bool(s) {
 			return 1
 		} else {
 			return 0
 		}
-	case nil:
-		return 0
 	default:
 		return 0
 	}
@@ -278,18 +358,15 @@ func (conf *Configuration) GetInt(key string) int {
 func (conf *Configuration) GetBool(key string) bool {
 	ret, _ := conf.Get(key)
 
+	if n, ok := toFloat(ret); ok {
+		return n > 0
+	}
+
 	switch b := ret.(type) {
 	case bool:
 		return b
-	case nil:
-		return false
-	case int:
-		if ret.(int) > 0 {
-			return true
-		}
-		return false
 	case string:
-		ret1, err := strconv.ParseBool(ret.(string))
+		ret1, err := strconv.ParseBool(b)
 		if err != nil {
 			return false
 		}
@@ -307,25 +384,7 @@ func (conf *Configuration) GetStringSlice(key string) []string {
 	switch v := ret.(type) {
 	case []interface{}:
 		for _, u := range v {
-
-			var w string
-
-			switch s := u.(type) {
-			case string:
-				w = s
-			case float64:
-				w = strconv.FormatFloat(u.(float64), 'f', -1, 64)
-			case int:
-				w = strconv.FormatInt(int64(u.(int)), 10)
-			case []byte:
-				w = string(s)
-			case nil:
-				w = ""
-			default:
-				w = ""
-			}
-
-			a = append(a, w)
+			a = append(a, toString(u))
 		}
 		return a
 	case []string:
@@ -335,6 +394,69 @@ func (conf *Configuration) GetStringSlice(key string) []string {
 	}
 }
 
+// toString formats value, numbers are same whatever type decoder gave them (6667 == 6667.0)
+func toString(value interface{}) string {
+	switch s := value.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(s), 'f', -1, 32)
+	case []byte:
+		return string(s)
+	}
+	if n, ok := toInt(value); ok {
+		return strconv.FormatInt(n, 10)
+	}
+	return ""
+}
+
+// toInt return's value of any number type as int64, floats are truncated
+func toInt(value interface{}) (int64, bool) {
+	switch n := value.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float32:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// toFloat return's value of any number type as float64
+func toFloat(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	if i, ok := toInt(value); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
 func (conf *Configuration) String() string {
 	conf.RLock()
 	defer conf.RUnlock()
@@ -350,11 +472,33 @@ func (conf *Configuration) String() string {
 
 func (conf *Configuration) LoadExampleConfig() {
 	conf.HostName = "irc.deltaanime.net"
-	conf.Owner = "Natrim"
+	conf.Owner = StringList{"Natrim!*@*"}
 	conf.UpdateUrl = "http://natrim.cz/uploads/grainbot_linux"
+	conf.UserRate = 10
+	conf.SlowDownNotice = "slow down, {nick}! i need a nap."
 	conf.Modules = map[string]interface{}{"autojoin": map[string]interface{}{"channels": []string{"#pony"}}}
 }
 
+// LoadExampleConfigTo fills example config which is saved to file (in format by its extension),
+// relative file is in current directory and empty one is config.json next to bot
+func (conf *Configuration) LoadExampleConfigTo(file string) error {
+	conf.LoadExampleConfig()
+	if file == "" {
+		return nil
+	}
+
+	filename, err := filepath.Abs(file)
+	if err != nil {
+		return errors.New("Cannot use config file! " + err.Error())
+	}
+
+	conf.Lock()
+	conf.filepath = filename
+	conf.modTime = time.Time{} //nothing loaded, file is written whatever is there
+	conf.Unlock()
+	return nil
+}
+
 func NewExampleConfiguration() *Configuration {
 	conf := NewConfiguration()
 	conf.LoadExampleConfig()
@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var path string
@@ -142,3 +144,306 @@ func TestModuleThings(t *testing.T) {
 		return
 	}
 }
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+
+	if err := ioutil.WriteFile(file, []byte(NewExampleConfiguration().String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadConfigFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := NewExampleConfiguration()
+	changed.Owner = StringList{"Twilight!*@*"}
+	changed.Modules["autojoin"] = map[string]interface{}{"channels": []string{"#pony", "#library"}}
+	if err := ioutil.WriteFile(file, []byte(changed.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Second)) //filesystems with coarse mtime
+	if !conf.Modified() {
+		t.Error("Changed file not detected")
+	}
+
+	changes, err := conf.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.String() != "Owner, autojoin.channels" || !changes.Has("autojoin") || changes.Has("HostName") {
+		t.Error("Wrong changes: ", changes)
+	}
+	if conf.Owner[0] != "Twilight!*@*" || len(conf.GetStringSlice("autojoin.channels")) != 2 || conf.Modified() {
+		t.Error("Config not reloaded: ", conf)
+	}
+
+	changed.HostName = ""
+	ioutil.WriteFile(file, []byte(changed.String()), 0600)
+	if _, err := conf.Reload(); err == nil {
+		t.Error("Invalid config reloaded")
+	}
+	if conf.HostName == "" {
+		t.Error("Invalid config replaced current one")
+	}
+}
+
+func TestSafeSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+
+	//absolute path
+	if err := NewExampleConfiguration().SaveToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal("Config not saved to absolute path: ", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("New config is readable by others: ", info.Mode())
+	}
+
+	conf, err := LoadConfigFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ConfigBackups = 2
+	for _, nick := range []string{"Twilight", "Rarity", "Applejack"} {
+		conf.Nick = nick
+		if err := conf.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, _ := LoadConfigFromFile(file)
+	backup, _ := LoadConfigFromFile(file + ".1")
+	oldest, _ := LoadConfigFromFile(file + ".2")
+	if saved.Nick != "Applejack" || backup.Nick != "Rarity" || oldest.Nick != "Twilight" {
+		t.Error("Wrong backups: ", saved.Nick, backup.Nick, oldest.Nick)
+	}
+	if _, err := os.Stat(file + ".3"); !os.IsNotExist(err) {
+		t.Error("Too many backups")
+	}
+
+	//changed on disk
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Minute))
+	conf.Nick = "Pinkie"
+	if err := conf.Save(); err != ErrConfigModified {
+		t.Error("Changed file overwritten: ", err)
+	}
+	if err := conf.ForceSave(); err != nil {
+		t.Error(err)
+	}
+	if saved, _ := LoadConfigFromFile(file); saved.Nick != "Pinkie" {
+		t.Error("Forced save failed: ", saved.Nick)
+	}
+}
+
+func TestSaveRelative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("conf.yaml", []byte("hostname: irc.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadConfigFromFile("conf.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//relative path is the loaded file, not one next to binary
+	conf.Nick = "Scootaloo"
+	if err := conf.SaveToFile("conf.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := LoadConfigFromFile(filepath.Join(dir, "conf.yaml")); err != nil || saved.Nick != "Scootaloo" {
+		t.Error("Relative path not saved to loaded file: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "conf.yaml.1")); err != nil {
+		t.Error("Loaded file not backed up: ", err)
+	}
+
+	os.Chtimes("conf.yaml", time.Now(), time.Now().Add(time.Minute))
+	if err := conf.SaveToFile("./conf.yaml"); err != ErrConfigModified {
+		t.Error("Changed file overwritten: ", err)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.json": `{"HostName": "irc.pony.net", "Port": 6697, "Owner": "Twilight!*@*",
+			"Modules": {"pony": {"port": 6667, "ratio": 1.5, "enabled": 1, "channels": ["#pony", 42]}}}`,
+		"config.yaml": "hostname: irc.pony.net\nport: 6697\nowner: Twilight!*@*\n" +
+			"modules:\n  pony:\n    port: 6667\n    ratio: 1.5\n    enabled: 1\n    channels: ['#pony', 42]\n",
+		"config.toml": "HostName = \"irc.pony.net\"\nPort = 6697\nOwner = \"Twilight!*@*\"\n" +
+			"[Modules.pony]\nport = 6667\nratio = 1.5\nenabled = 1\nchannels = [\"#pony\", 42]\n",
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(conf *Configuration, what string) {
+			if conf.HostName != "irc.pony.net" || conf.Port != 6697 || len(conf.Owner) != 1 {
+				t.Errorf("%s %s: wrong fields: %s", name, what, conf)
+			}
+			if conf.GetInt("pony.port") != 6667 || conf.GetString("pony.port") != "6667" || conf.GetString("pony.ratio") != "1.5" {
+				t.Errorf("%s %s: wrong numbers: %#v", name, what, conf.Modules)
+			}
+			if !conf.GetBool("pony.enabled") || strings.Join(conf.GetStringSlice("pony.channels"), ",") != "#pony,42" {
+				t.Errorf("%s %s: wrong values: %#v", name, what, conf.Modules)
+			}
+		}
+
+		conf, err := LoadConfigFromFile(file)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		check(conf, "load")
+
+		//saved in same format
+		conf.Nick = "Spike"
+		if err := conf.Save(); err != nil {
+			t.Fatal(name, err)
+		}
+		saved, err := LoadConfigFromFile(file)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		check(saved, "save")
+		if saved.Nick != "Spike" {
+			t.Error(name, "not saved")
+		}
+		if buff, _ := ioutil.ReadFile(file); !strings.HasSuffix(name, ".json") && strings.HasPrefix(string(buff), "{") {
+			t.Error(name, "saved as json")
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "generated.yaml")
+	conf := NewConfiguration()
+	if err := conf.LoadFromFile(file); err == nil {
+		t.Fatal("Missing file loaded")
+	}
+	if err := conf.LoadExampleConfigTo(file); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Dir() != dir {
+		t.Error("Wrong config dir: ", conf.Dir())
+	}
+	if err := conf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal("Config not saved to requested file: ", err)
+	}
+	if strings.HasPrefix(string(buff), "{") {
+		t.Error("Config not saved as yaml")
+	}
+	saved, err := LoadConfigFromFile(file)
+	if err != nil || saved.HostName != conf.HostName || len(saved.GetStringSlice("autojoin.channels")) != 1 {
+		t.Errorf("Wrong generated config: %v %s", err, saved)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+
+	if err := ioutil.WriteFile(file, []byte(NewExampleConfiguration().String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(file, 0644)
+	conf, err := LoadConfigFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = conf.OverrideFromEnv([]string{
+		"HOME=/root",
+		"GRAINBOT_SASL_PASSWORD=secret",
+		"GRAINBOT_NICK=Spike",
+		"GRAINBOT_PORT=6697",
+		"GRAINBOT_MODULES_AUTOJOIN_CHANNELS=#pony,#library",
+		"GRAINBOT_MODULES_DICE_MAX=5",
+		"GRAINBOT_PONY=1",
+		"GRAINBOT_USERRATE=many",
+	})
+	if err == nil || !strings.Contains(err.Error(), "GRAINBOT_PONY") || !strings.Contains(err.Error(), "GRAINBOT_USERRATE") {
+		t.Error("Invalid variables not reported: ", err)
+	}
+	if err := conf.Override("Nick", "Twilight"); err != nil { //flag after environment
+		t.Fatal(err)
+	}
+
+	if conf.SASLPassword != "secret" || conf.Nick != "Twilight" || conf.Port != 6697 || conf.UserRate != 10 {
+		t.Error("Wrong overrides: ", conf)
+	}
+	if strings.Join(conf.GetStringSlice("autojoin.channels"), ",") != "#pony,#library" || conf.GetInt("dice.max") != 5 {
+		t.Error("Wrong module overrides: ", conf.Modules)
+	}
+
+	//only file layer is saved
+	conf.UserRate = 20
+	if err := conf.Save(); err != nil {
+		t.Fatal(err)
+	}
+	buff, _ := ioutil.ReadFile(file)
+	saved, _ := LoadConfigFromFile(file)
+	if strings.Contains(string(buff), "secret") || saved.Nick != "" || saved.Port != 0 || saved.UserRate != 20 {
+		t.Error("Overrides saved: ", string(buff))
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0644 {
+		t.Error("Mode changed by secret which is not saved: ", info.Mode())
+	}
+	if strings.Join(saved.GetStringSlice("autojoin.channels"), ",") != "#pony" || saved.Modules["dice"] != nil {
+		t.Error("Module overrides saved: ", saved.Modules)
+	}
+
+	//overrides survive reload
+	if _, err := conf.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.SASLPassword != "secret" || conf.Nick != "Twilight" || conf.GetInt("dice.max") != 5 {
+		t.Error("Overrides lost on reload: ", conf)
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// formats of config file by extension
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configNames are tried in bot directory when no config file is given
+var configNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+func formatOf(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON
+}
+
+// decode load's data in format into config, keys are matched like json keys (case insensitive)
+func decode(format string, data []byte, conf *Configuration) error {
+	var raw map[string]interface{}
+	switch format {
+	case formatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	case formatTOML:
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	default:
+		return json.Unmarshal(data, conf)
+	}
+	raw = normalize(raw).(map[string]interface{})
+
+	//fields through json, so they behave same in all formats
+	buff, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buff, conf); err != nil {
+		return err
+	}
+
+	//module values keep types of decoder
+	for key, value := range raw {
+		if strings.EqualFold(key, "Modules") {
+			modules, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				return fmt.Errorf("Modules must be map, not %T!", value)
+			}
+			conf.Modules = modules
+		}
+	}
+	return nil
+}
+
+// encode return's config in format, called with lock held
+func encode(format string, conf *Configuration) ([]byte, error) {
+	if format == formatJSON {
+		return json.MarshalIndent(conf, "", "    ")
+	}
+
+	buff, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buff))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	raw = normalize(raw).(map[string]interface{})
+	if conf.Modules != nil {
+		raw["Modules"] = normalize(conf.Modules)
+	}
+
+	if format == formatYAML {
+		return yaml.Marshal(raw)
+	}
+
+	var out bytes.Buffer
+	err = toml.NewEncoder(&out).Encode(dropNil(raw))
+	return out.Bytes(), err
+}
+
+// normalize convert's yaml maps to string maps and json numbers to ints or floats, so all decoders give same types
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = normalize(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = normalize(value)
+		}
+		return l
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// dropNil remove's empty values from maps, toml has no null
+func dropNil(m map[string]interface{}) map[string]interface{} {
+	for key, value := range m {
+		switch v := value.(type) {
+		case nil:
+			delete(m, key)
+		case map[string]interface{}:
+			m[key] = dropNil(v)
+		}
+	}
+	return m
+}
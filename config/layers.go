@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is prefix of environment variables overriding config (eg. GRAINBOT_NICK or GRAINBOT_MODULES_AUTOJOIN_CHANNELS)
+const EnvPrefix = "GRAINBOT_"
+
+// override is value from environment or command line, it is applied over config file and never saved
+type override struct {
+	key   string
+	value string
+}
+
+// fileValue is value of overridden key from config file, saved instead of the override
+type fileValue struct {
+	value   interface{}
+	present bool //module key can be missing in file
+}
+
+// Override set's key (field like "Nick" or module key like "autojoin.channels") over config file,
+// lists are comma separated or json, maps are json - the value survives reload and is never saved
+func (conf *Configuration) Override(key, value string) error {
+	conf.Lock()
+	defer conf.Unlock()
+
+	if err := conf.override(key, value); err != nil {
+		return err
+	}
+	conf.overrides = append(conf.overrides, override{key, value})
+	return nil
+}
+
+// OverrideFromEnv applies GRAINBOT_* variables of environment (as from os.Environ) by Override
+func (conf *Configuration) OverrideFromEnv(environ []string) error {
+	var failed []string
+	for _, env := range environ {
+		if !strings.HasPrefix(env, EnvPrefix) {
+			continue
+		}
+		i := strings.Index(env, "=")
+		if i < 0 {
+			continue
+		}
+		name, value := env[len(EnvPrefix):i], env[i+1:]
+
+		key := name
+		if strings.HasPrefix(strings.ToUpper(name), "MODULES_") {
+			parts := strings.SplitN(strings.ToLower(name[len("MODULES_"):]), "_", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				failed = append(failed, env[:i]+" (use "+EnvPrefix+"MODULES_<MODULE>_<KEY>)")
+				continue
+			}
+			key = parts[0] + "." + parts[1]
+		}
+
+		if err := conf.Override(key, value); err != nil {
+			failed = append(failed, env[:i]+" ("+err.Error()+")")
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("Invalid config environment variables: " + strings.Join(failed, ", ") + "!")
+	}
+	return nil
+}
+
+// override applies value, called with lock held
+func (conf *Configuration) override(key, value string) error {
+	if conf.fileValues == nil {
+		conf.fileValues = make(map[string]fileValue)
+	}
+
+	if strings.Contains(key, ".") {
+		return conf.overrideModule(strings.ToLower(key), value)
+	}
+
+	field, name, ok := conf.field(key)
+	if !ok {
+		return errors.New("Unknown config key \"" + key + "\"!")
+	}
+
+	parsed := reflect.New(field.Type()).Elem()
+	switch field.Kind() {
+	case reflect.String:
+		parsed.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("\"" + value + "\" is not a number!")
+		}
+		parsed.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("\"" + value + "\" is not a bool!")
+		}
+		parsed.SetBool(b)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if err := json.Unmarshal([]byte(value), parsed.Addr().Interface()); err != nil {
+				return err
+			}
+		} else {
+			parsed.Set(reflect.ValueOf(splitList(value)).Convert(field.Type()))
+		}
+	case reflect.Map:
+		if err := json.Unmarshal([]byte(value), parsed.Addr().Interface()); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Config key \"" + key + "\" can not be overridden!")
+	}
+
+	if _, ok := conf.fileValues[name]; !ok {
+		conf.fileValues[name] = fileValue{value: field.Interface(), present: true}
+	}
+	field.Set(parsed)
+	return nil
+}
+
+func (conf *Configuration) overrideModule(key, value string) error {
+	keys := strings.SplitN(key, ".", 2)
+	module, name := keys[0], keys[1]
+
+	if conf.Modules == nil {
+		conf.Modules = make(map[string]interface{})
+	}
+	values, ok := conf.Modules[module].(map[string]interface{})
+	if !ok {
+		values = make(map[string]interface{})
+		conf.Modules[module] = values
+	}
+	current, present := values[name]
+
+	//json values, comma separated list if file has list there, else string
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		if _, list := current.([]interface{}); list || strings.Contains(value, ",") {
+			var l []interface{}
+			for _, s := range splitList(value) {
+				l = append(l, s)
+			}
+			parsed = l
+		} else {
+			parsed = value
+		}
+	}
+
+	if _, ok := conf.fileValues[key]; !ok {
+		conf.fileValues[key] = fileValue{value: current, present: present}
+	}
+	values[name] = parsed
+	return nil
+}
+
+// field find's exported field by name, case and underscores are ignored (SASL_PASSWORD is SASLPassword)
+func (conf *Configuration) field(key string) (reflect.Value, string, bool) {
+	key = strings.Replace(key, "_", "", -1)
+	v := reflect.ValueOf(conf).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" || f.Anonymous || f.Name == "Modules" {
+			continue
+		}
+		if strings.EqualFold(f.Name, key) {
+			return v.Field(i), f.Name, true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// fileLayer return's config as it should be in file - with file values instead of overrides, called with lock held
+func (conf *Configuration) fileLayer() *Configuration {
+	if len(conf.fileValues) == 0 {
+		return conf
+	}
+
+	layer := NewConfiguration()
+	copyFields(layer, conf)
+	layer.Modules = make(map[string]interface{}, len(conf.Modules))
+	for module, values := range conf.Modules {
+		if m, ok := values.(map[string]interface{}); ok {
+			copied := make(map[string]interface{}, len(m))
+			for key, value := range m {
+				copied[key] = value
+			}
+			values = copied
+		}
+		layer.Modules[module] = values
+	}
+
+	for key, original := range conf.fileValues {
+		if !strings.Contains(key, ".") {
+			field, _, _ := layer.field(key)
+			field.Set(reflect.ValueOf(original.value))
+			continue
+		}
+
+		keys := strings.SplitN(key, ".", 2)
+		values, _ := layer.Modules[keys[0]].(map[string]interface{})
+		if values == nil {
+			continue
+		}
+		if original.present {
+			values[keys[1]] = original.value
+			continue
+		}
+		delete(values, keys[1])
+		if len(values) == 0 {
+			delete(layer.Modules, keys[0])
+		}
+	}
+	if len(layer.Modules) == 0 {
+		layer.Modules = nil
+	}
+
+	return layer
+}
+
+// copyFields copies exported fields, lock stays
+func copyFields(dst, src *Configuration) {
+	to, from := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < to.NumField(); i++ {
+		if field := to.Type().Field(i); field.PkgPath == "" && !field.Anonymous {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Changes are keys changed by reload, top-level ones (eg. "Owner") and module ones (eg. "autojoin.channels")
+type Changes []string
+
+// Has return's true if key changed, module name (eg. "autojoin") matches any of its keys
+func (c Changes) Has(key string) bool {
+	key = strings.ToLower(key)
+	for _, changed := range c {
+		changed = strings.ToLower(changed)
+		if changed == key || strings.HasPrefix(changed, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Changes) String() string {
+	if len(c) == 0 {
+		return "nothing"
+	}
+	return strings.Join(c, ", ")
+}
+
+// Validate checks if configuration is usable by bot
+func (conf *Configuration) Validate() error {
+	conf.RLock()
+	defer conf.RUnlock()
+
+	if conf.HostName == "" {
+		return errors.New("No hostname defined!")
+	}
+	if conf.Port < 0 || conf.Port > 65535 {
+		return errors.New("Invalid port " + strconv.Itoa(conf.Port) + "!")
+	}
+	if conf.UserRate < 0 {
+		return errors.New("Invalid user rate " + strconv.Itoa(conf.UserRate) + "!")
+	}
+	for name, module := range conf.Modules {
+		if _, ok := module.(map[string]interface{}); !ok && module != nil {
+			return errors.New("Config of module \"" + name + "\" is not an object!")
+		}
+	}
+	return nil
+}
+
+// Reload load's config file again, if it is valid it replaces current config and return's changed keys
+func (conf *Configuration) Reload() (Changes, error) {
+	conf.RLock()
+	file, overrides := conf.filepath, conf.overrides
+	conf.RUnlock()
+
+	fresh := NewConfiguration()
+	if err := fresh.LoadFromFile(file); err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		if err := fresh.Override(o.key, o.value); err != nil {
+			return nil, err
+		}
+	}
+	if err := fresh.Validate(); err != nil {
+		return nil, errors.New("Invalid config file! " + err.Error())
+	}
+
+	conf.Lock()
+	defer conf.Unlock()
+
+	changes, err := diff(conf, fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	copyFields(conf, fresh)
+	conf.filepath = fresh.filepath
+	conf.modTime = fresh.modTime
+	conf.overrides = fresh.overrides
+	conf.fileValues = fresh.fileValues
+
+	return changes, nil
+}
+
+// Modified return's true if config file was changed by someone else since it was loaded or saved
+func (conf *Configuration) Modified() bool {
+	conf.RLock()
+	defer conf.RUnlock()
+	return conf.changedOnDisk()
+}
+
+func fileModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// diff return's keys with different values, called with locks held
+func diff(current, fresh *Configuration) (Changes, error) {
+	a, err := toMap(current)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toMap(fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffMaps(a, b, "", func(key string, x, y interface{}) Changes {
+		if key != "Modules" {
+			return Changes{key}
+		}
+		//module keys separately
+		mx, _ := x.(map[string]interface{})
+		my, _ := y.(map[string]interface{})
+		return diffMaps(mx, my, "", func(module string, x, y interface{}) Changes {
+			kx, okx := x.(map[string]interface{})
+			ky, oky := y.(map[string]interface{})
+			if !okx && !oky {
+				return Changes{module}
+			}
+			return diffMaps(kx, ky, module+".", func(key string, x, y interface{}) Changes {
+				return Changes{key}
+			})
+		})
+	})
+
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// diffMaps call's changed for keys of a and b with different values
+func diffMaps(a, b map[string]interface{}, prefix string, changed func(key string, x, y interface{}) Changes) Changes {
+	var changes Changes
+	for key, x := range a {
+		if y, ok := b[key]; !ok || !reflect.DeepEqual(x, y) {
+			changes = append(changes, changed(prefix+key, x, y)...)
+		}
+	}
+	for key, y := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, changed(prefix+key, nil, y)...)
+		}
+	}
+	return changes
+}
+
+func toMap(conf *Configuration) (map[string]interface{}, error) {
+	buff, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	return m, json.Unmarshal(buff, &m)
+}
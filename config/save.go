@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrConfigModified is returned by save when config file was changed on disk since it was loaded
+var ErrConfigModified = errors.New("Config file was changed on disk since it was loaded! Reload it or force save.")
+
+const defaultBackups = 3
+
+// changedOnDisk return's true if loaded file was modified by someone else, called with lock held
+func (conf *Configuration) changedOnDisk() bool {
+	if conf.filepath == "" || conf.modTime.IsZero() {
+		return false
+	}
+	if _, err := os.Stat(conf.filepath); os.IsNotExist(err) {
+		return false //deleted file is just written again
+	}
+	return !fileModTime(conf.filepath).Equal(conf.modTime)
+}
+
+// fileMode return's permissions for config file - the existing ones or 0600, never readable by others with secrets in it,
+// called on layer which is written (overridden secret is not in the file)
+func (conf *Configuration) fileMode(file string) os.FileMode {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if conf.SASLPassword != "" {
+		mode &= 0700
+	}
+	return mode
+}
+
+func (conf *Configuration) backups() int {
+	switch {
+	case conf.ConfigBackups < 0:
+		return 0
+	case conf.ConfigBackups == 0:
+		return defaultBackups
+	}
+	return conf.ConfigBackups
+}
+
+// WriteFile replaces file atomically - data are written and synced to temp file which is renamed over file,
+// previous versions are kept as file.1 (newest) to file.N
+func WriteFile(file string, data []byte, mode os.FileMode, backups int) error {
+	if target, err := filepath.EvalSymlinks(file); err == nil {
+		file = target //keep symlink
+	}
+
+	if old, err := ioutil.ReadFile(file); err == nil && bytes.Equal(old, data) {
+		return os.Chmod(file, mode) //nothing changed
+	}
+
+	dir := filepath.Dir(file)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //fails after successful rename
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := backup(file, backups); err != nil {
+		return errors.New("Cannot backup config file! " + err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+
+	//persist the rename, not supported everywhere
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// backup rotate's backups of file and keeps current one as file.1
+func backup(file string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+
+	name := func(i int) string {
+		return file + "." + strconv.Itoa(i)
+	}
+	os.Remove(name(backups))
+	for i := backups - 1; i > 0; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	//current file stays in place until it is replaced
+	if err := os.Link(file, name(1)); err == nil {
+		return nil
+	}
+	return copyFile(file, name(1))
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package ignore
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/permissions"
+)
+
+// Entry ignores users matching mask (nick!user@host glob or $a:account)
+type Entry struct {
+	Mask    string    `json:"mask"`
+	Scope   string    `json:"scope,omitempty"` //empty for global, channel or module name
+	Expires time.Time `json:"expires"`         //zero for never
+}
+
+// Expired return's true if entry is no longer valid
+func (e Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// Key return's mask and scope of entry
+func (e *Entry) Key() (string, string) {
+	return e.Mask, e.Scope
+}
+
+// List is persistent ignore list, it implements irc.Ignorer
+type List struct {
+	Exempt irc.Validator //users allowed by it are never ignored (owners)
+
+	*permissions.MaskList
+}
+
+// DefaultList is the ignore list of bot
+var DefaultList = NewList()
+
+func NewList() *List {
+	return &List{MaskList: permissions.NewMaskList("ignore list", func() permissions.MaskEntry { return &Entry{} }, func(a, b permissions.MaskEntry) bool {
+		return a.(*Entry).Scope < b.(*Entry).Scope
+	})}
+}
+
+// Add ignores mask in scope for duration (0 for ever), replacing previous ignore of the mask in same scope
+func (l *List) Add(mask, scope string, duration time.Duration) error {
+	if mask == "" {
+		return errors.New("Empty mask!")
+	}
+
+	entry := &Entry{Mask: permissions.NormalizeUserMask(mask), Scope: scope}
+	if duration > 0 {
+		entry.Expires = time.Now().Add(duration)
+	}
+	return l.Put(entry)
+}
+
+// List return's not expired ignores sorted by scope
+func (l *List) List() []Entry {
+	var entries []Entry
+	for _, e := range l.Entries() {
+		entries = append(entries, *e.(*Entry))
+	}
+	return entries
+}
+
+// Ignored return's true if sender of message is ignored globally, in channel of message or in scope (module)
+func (l *List) Ignored(m *irc.Message, scope string) bool {
+	if l.Exempt != nil && l.Exempt.Validate(m) {
+		return false
+	}
+
+	fold := permissions.FoldOf(m)
+
+	ignored := false
+	l.Each(func(e permissions.MaskEntry) {
+		entry := e.(*Entry)
+		if ignored || entry.Scope != "" && !strings.EqualFold(entry.Scope, scope) && (m.Channel == "" || fold(entry.Scope) != fold(m.Channel)) {
+			return
+		}
+		ignored = permissions.MatchUser(entry.Mask, m)
+	})
+
+	return ignored
+}
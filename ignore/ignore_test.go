@@ -0,0 +1,78 @@
+package ignore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/natrim/grainbot/ignore"
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/permissions"
+)
+
+func TestIgnoreScopes(t *testing.T) {
+	list := NewList()
+	list.Add("*!*@spam.bots", "", 0)
+	list.Add("Derpy", "#pony", 0)
+	list.Add("$a:discord", "dice", 0)
+
+	message := func(raw string) *irc.Message {
+		m := irc.ParseMessage(raw)
+		m.Channel = m.Arguments[0]
+		return m
+	}
+
+	if !list.Ignored(message(":bot1!b@spam.bots PRIVMSG #canterlot :buy!"), "coin") {
+		t.Error("Global ignore not used")
+	}
+	if !list.Ignored(message(":derpy!d@cloud PRIVMSG #pony :muffins"), "coin") || list.Ignored(message(":derpy!d@cloud PRIVMSG #canterlot :muffins"), "coin") {
+		t.Error("Channel ignore not used or used in other channel")
+	}
+	chaos := message("@account=Discord :Twilight!t@library PRIVMSG #pony :.dice")
+	if !list.Ignored(chaos, "dice") || list.Ignored(chaos, "coin") {
+		t.Error("Module ignore not used or used in other module")
+	}
+
+	list.Exempt = permissions.NewHostmaskPermission("bot1")
+	if list.Ignored(message(":bot1!b@spam.bots PRIVMSG #canterlot :buy!"), "coin") {
+		t.Error("Exempt user ignored")
+	}
+}
+
+func TestIgnoreExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ignore.json")
+
+	list := NewList()
+	list.Load(file)
+	list.Add("Spike", "", 20*time.Millisecond)
+	list.Add("Gilda", "", 0)
+
+	spike := irc.ParseMessage(":Spike!s@library PRIVMSG #pony :gems")
+	if !list.Ignored(spike, "") {
+		t.Error("Temporary ignore not used")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if list.Ignored(spike, "") || len(list.List()) != 1 {
+		t.Error("Ignore not expired")
+	}
+
+	if err := list.Remove("gilda", ""); err != nil {
+		t.Error(err)
+	}
+	list.Add("Trixie", "#pony", time.Hour)
+
+	loaded := NewList()
+	if err := loaded.Load(file); err != nil {
+		t.Fatal(err)
+	}
+	if entries := loaded.List(); len(entries) != 1 || entries[0].Mask != "Trixie!*@*" || entries[0].Scope != "#pony" || entries[0].Expires.IsZero() {
+		t.Error("Ignore list not persisted: ", entries)
+	}
+}
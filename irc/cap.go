@@ -0,0 +1,307 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Known IRCv3 capabilities
+const (
+	CapServerTime      = "server-time"
+	CapAccountTag      = "account-tag"
+	CapAccountNotify   = "account-notify"
+	CapAwayNotify      = "away-notify"
+	CapExtendedJoin    = "extended-join"
+	CapMultiPrefix     = "multi-prefix"
+	CapEchoMessage     = "echo-message"
+	CapMessageTags     = "message-tags"
+	CapBatch           = "batch"
+	CapCapNotify       = "cap-notify"
+	CapUserhostNames   = "userhost-in-names"
+	CapChghost         = "chghost"
+	CapLabeledResponse = "labeled-response"
+)
+
+// capabilities hold's the state of CAP negotiation
+type capabilities struct {
+	sync.RWMutex
+
+	wanted    map[string]bool   //capabilities requested by bot or modules
+	available map[string]string //capabilities offered by server with their values
+	enabled   map[string]bool   //capabilities acknowledged by server
+
+	listing     bool //receiving multiline CAP LS
+	pending     int  //CAP REQ's waiting for ACK/NAK
+	holds       int  //things holding the CAP END (eg. SASL)
+	negotiating bool //is the registration held by us?
+}
+
+func newCapabilities() *capabilities {
+	return &capabilities{
+		wanted:    map[string]bool{CapCapNotify: true},
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+	}
+}
+
+// reset forget's everything server told us
+func (c *capabilities) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.available = make(map[string]string)
+	c.enabled = make(map[string]bool)
+	c.listing = false
+	c.pending = 0
+	c.holds = 0
+	c.negotiating = false
+}
+
+// RequestCap declares capabilities wanted by bot, if already connected they are requested immediately
+func (irc *Connection) RequestCap(names ...string) {
+	irc.caps.Lock()
+	var request []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		irc.caps.wanted[name] = true
+		if _, ok := irc.caps.available[name]; ok && !irc.caps.enabled[name] && !irc.caps.listing {
+			request = append(request, name)
+		}
+	}
+	if len(request) > 0 && irc.IsConnected {
+		irc.caps.pending++
+	} else {
+		request = nil
+	}
+	irc.caps.Unlock()
+
+	if len(request) > 0 {
+		irc.SendRawf("CAP REQ :%s", strings.Join(request, " "))
+	}
+}
+
+// HasCap return's true if capability is enabled on connection
+func (irc *Connection) HasCap(name string) bool {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	return irc.caps.enabled[strings.ToLower(name)]
+}
+
+// CapValue return's value of capability offered by server (eg. sasl mechanisms)
+func (irc *Connection) CapValue(name string) (string, bool) {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	value, ok := irc.caps.available[strings.ToLower(name)]
+	return value, ok
+}
+
+// Caps return's list of enabled capabilities
+func (irc *Connection) Caps() []string {
+	irc.caps.RLock()
+	defer irc.caps.RUnlock()
+	caps := make([]string, 0, len(irc.caps.enabled))
+	for name := range irc.caps.enabled {
+		caps = append(caps, name)
+	}
+	return caps
+}
+
+// startCapNegotiation hold's registration until CAP END
+func (irc *Connection) startCapNegotiation() {
+	irc.caps.reset()
+	irc.caps.Lock()
+	irc.caps.negotiating = true
+	irc.caps.listing = true
+	irc.caps.Unlock()
+	irc.SendRaw("CAP LS 302")
+}
+
+// refreshCaps ask's server for capabilities of already registered connection (after restart)
+func (irc *Connection) refreshCaps() {
+	irc.caps.reset()
+	irc.caps.Lock()
+	irc.caps.listing = true
+	irc.caps.Unlock()
+	irc.SendRaw("CAP LS 302")
+	irc.SendRaw("CAP LIST")
+}
+
+// finishCapNegotiation is called on registration, server without CAP support does not hold us
+func (irc *Connection) finishCapNegotiation() {
+	irc.caps.Lock()
+	irc.caps.negotiating = false
+	irc.caps.listing = false
+	irc.caps.Unlock()
+}
+
+// holdRegistration delay's CAP END until releaseRegistration is called
+func (irc *Connection) holdRegistration() {
+	irc.caps.Lock()
+	irc.caps.holds++
+	irc.caps.Unlock()
+}
+
+// releaseRegistration release's hold and end's negotiation if nothing else waits
+func (irc *Connection) releaseRegistration() {
+	irc.caps.Lock()
+	if irc.caps.holds > 0 {
+		irc.caps.holds--
+	}
+	irc.caps.Unlock()
+	irc.endCapNegotiation()
+}
+
+// endCapNegotiation send's CAP END if nothing waits for it
+func (irc *Connection) endCapNegotiation() {
+	irc.caps.Lock()
+	if !irc.caps.negotiating || irc.caps.listing || irc.caps.pending > 0 || irc.caps.holds > 0 {
+		irc.caps.Unlock()
+		return
+	}
+	irc.caps.negotiating = false
+	irc.caps.Unlock()
+
+	irc.SendRaw("CAP END")
+}
+
+// handleCap process's CAP replies from server
+func (irc *Connection) handleCap(event *Message) {
+	if len(event.Arguments) < 2 {
+		return
+	}
+
+	args := event.Arguments[1:]
+	subcommand := strings.ToUpper(args[0])
+	args = args[1:]
+
+	more := false
+	if len(args) > 1 && args[0] == "*" {
+		more = true
+		args = args[1:]
+	}
+
+	list := ""
+	if len(args) > 0 {
+		list = args[len(args)-1]
+	}
+
+	switch subcommand {
+	case "LS", "NEW":
+		irc.caps.Lock()
+		offered := parseCapList(list)
+		for name, value := range offered {
+			irc.caps.available[name] = value
+		}
+		if subcommand == "LS" && more {
+			irc.caps.Unlock()
+			return
+		}
+		if subcommand == "LS" {
+			irc.caps.listing = false
+			offered = irc.caps.available
+		}
+
+		//NEW request's only new ones, rejected capabilities are not asked again
+		var request []string
+		for name := range irc.caps.wanted {
+			if _, ok := offered[name]; ok && !irc.caps.enabled[name] {
+				request = append(request, name)
+			}
+		}
+		irc.caps.Unlock()
+
+		irc.requestCaps(request)
+		irc.endCapNegotiation()
+
+	case "LIST":
+		irc.caps.Lock()
+		for name := range parseCapList(list) {
+			irc.caps.enabled[name] = true
+		}
+		irc.caps.Unlock()
+
+	case "ACK":
+		irc.caps.Lock()
+		acked := parseCapList(list)
+		for name := range acked {
+			if strings.HasPrefix(name, "-") {
+				delete(irc.caps.enabled, name[1:])
+			} else {
+				irc.caps.enabled[name] = true
+			}
+		}
+		if irc.caps.pending > 0 {
+			irc.caps.pending--
+		}
+		negotiating := irc.caps.negotiating
+		irc.caps.Unlock()
+
+		log.Debugf("Capabilities enabled: %s", list)
+
+		if _, ok := acked["sasl"]; ok && negotiating {
+			irc.startSASL()
+		}
+
+		irc.endCapNegotiation()
+
+	case "NAK":
+		irc.caps.Lock()
+		if irc.caps.pending > 0 {
+			irc.caps.pending--
+		}
+		irc.caps.Unlock()
+
+		log.Debugf("Capabilities rejected: %s", list)
+		irc.endCapNegotiation()
+
+	case "DEL":
+		irc.caps.Lock()
+		for name := range parseCapList(list) {
+			delete(irc.caps.available, name)
+			delete(irc.caps.enabled, name)
+		}
+		irc.caps.Unlock()
+
+		log.Debugf("Capabilities removed: %s", list)
+	}
+}
+
+// requestCaps send's CAP REQ in chunks that fit into line
+func (irc *Connection) requestCaps(names []string) {
+	line := ""
+	for _, name := range names {
+		if len(line)+len(name)+1 > 400 {
+			irc.sendCapReq(line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += name
+	}
+	if line != "" {
+		irc.sendCapReq(line)
+	}
+}
+
+func (irc *Connection) sendCapReq(list string) {
+	irc.caps.Lock()
+	irc.caps.pending++
+	irc.caps.Unlock()
+	irc.SendRawf("CAP REQ :%s", list)
+}
+
+// parseCapList parses "name=value name2" list
+func parseCapList(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, cap := range strings.Fields(list) {
+		kv := strings.SplitN(cap, "=", 2)
+		if len(kv) == 2 {
+			caps[strings.ToLower(kv[0])] = kv[1]
+		} else {
+			caps[strings.ToLower(kv[0])] = ""
+		}
+	}
+	return caps
+}
@@ -0,0 +1,74 @@
+package irc_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestCapNegotiation(t *testing.T) {
+	conn, server := irctest.Register(t, nil)
+	defer server.Close()
+	defer conn.Disconnect()
+
+	server.Expect(t, "CAP LS 302")
+	server.Expect(t, "USER grainbot")
+	server.Send(":irc.test CAP * LS * :multi-prefix away-notify sasl=PLAIN",
+		":irc.test CAP * LS :cap-notify batch unknown-cap")
+
+	request := server.Expect(t, "CAP REQ :")
+	requested := strings.Fields(strings.TrimPrefix(request, "CAP REQ :"))
+	if len(requested) != 4 || strings.Contains(request, "sasl") || strings.Contains(request, "unknown-cap") {
+		t.Errorf("Wrong capabilities requested: %q", request)
+	}
+	if line, ok := server.Wait(50*time.Millisecond, "CAP END"); ok {
+		t.Fatalf("Registration ended before ACK: %q", line)
+	}
+
+	server.Send(":irc.test CAP dashy ACK :" + strings.Join(requested, " "))
+	server.Expect(t, "CAP END")
+	if !conn.HasCap(CapMultiPrefix) || !conn.HasCap(CapAwayNotify) || !conn.HasCap(CapCapNotify) || !conn.HasCap(CapBatch) {
+		t.Error("Acknowledged capabilities not enabled: ", conn.Caps())
+	}
+
+	//cap-notify, rejected and accepted request of new capability
+	conn.RequestCap(CapEchoMessage, CapServerTime)
+	server.Send(":irc.test CAP dashy NEW :echo-message")
+	server.Expect(t, "CAP REQ :echo-message")
+	server.Send(":irc.test CAP dashy NAK :echo-message")
+	server.Send(":irc.test CAP dashy NEW :server-time")
+	server.Expect(t, "CAP REQ :server-time")
+	server.Send(":irc.test CAP dashy ACK :server-time")
+	irctest.Eventually(t, "new capability enabled", func() bool {
+		return conn.HasCap(CapServerTime)
+	})
+	if conn.HasCap(CapEchoMessage) {
+		t.Error("Rejected capability enabled")
+	}
+
+	server.Send(":irc.test CAP dashy DEL :away-notify")
+	irctest.Eventually(t, "capability removed", func() bool {
+		return !conn.HasCap(CapAwayNotify)
+	})
+	if _, ok := conn.CapValue(CapAwayNotify); ok {
+		t.Error("Removed capability still offered")
+	}
+}
+
+func TestCapRejected(t *testing.T) {
+	conn, server := irctest.Register(t, nil)
+	defer server.Close()
+	defer conn.Disconnect()
+
+	server.Expect(t, "CAP LS 302")
+	server.Send(":irc.test CAP * LS :multi-prefix batch")
+	server.Expect(t, "CAP REQ :")
+	server.Send(":irc.test CAP dashy NAK :multi-prefix batch")
+	server.Expect(t, "CAP END")
+	if len(conn.Caps()) != 0 {
+		t.Error("Rejected capabilities enabled: ", conn.Caps())
+	}
+}
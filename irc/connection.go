@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,20 +23,31 @@ type Connection struct {
 	Secured     bool   //use ssl connection?
 	IsConnected bool   //is server connected?
 
+	TLS TLSOptions //options of ssl connection
+
 	restarting   bool //is bot restarting itself?
 	reconnecting bool //is bot reconnecting to irc?
 
 	Socket net.Conn //connection socket
 
-	Nickname string //nickname the client will use
+	Nickname string //nickname the client will use, change it by SetNickname when connected
 	Password string //password used to log on to the server
 	Username string //supplied to the server as the "User name""
 	RealName string //supplied to the server as "Real name" or "ircname"
 
+	SASL      SASLMechanism //SASL mechanism used to log on to services, nil to disable
+	SASLAbort bool          //disconnect when SASL fails instead of continuing unauthenticated
+
+	MaxLines     int  //max lines of one split message, rest is replaced by trailer (0 for unlimited)
+	NoFloodLimit bool //disable flood protection (for local servers and tests)
+
+	Ignore Ignorer //consulted before handlers get PRIVMSG or NOTICE
+
 	heartbeatInterval float64 //interval, in seconds, to send PING messages for keepalive
 
 	// Communication channels
 	write     chan string            // Channel for writing messages to IRC server
+	written   chan struct{}          // Closed when write loop ends
 	broadcast *broadcast.Broadcaster // Channel like broadcasting of the irc messages
 	exit      chan struct{}          // Channel for notifying goroutine stop
 	ErrorChan chan error             // Channel for dumping errors
@@ -49,7 +61,20 @@ type Connection struct {
 	badness  time.Duration
 	lastsent time.Time
 
-	currentNickname string //current nick
+	currentNickname string //current nick, guarded by state lock
+	nickLock        sync.RWMutex
+
+	caps *capabilities //IRCv3 capability negotiation
+	sasl sasl          //SASL authentication state
+
+	state *State //tracked channels and users
+
+	features     ServerFeatures //parsed RPL_ISUPPORT
+	featuresLock sync.RWMutex
+
+	queries     []*query //queries waiting for replies
+	queriesLock sync.Mutex
+	labels      uint32 //counter of labeled-response labels
 }
 
 func NewConnection(nick, user, realname string) (irc *Connection) {
@@ -58,8 +83,20 @@ func NewConnection(nick, user, realname string) (irc *Connection) {
 		Username:  user,
 		RealName:  realname,
 		broadcast: broadcast.NewBroadcaster(1024),
+		caps:      newCapabilities(),
+		state:     NewState(),
+		features:  DefaultFeatures(),
 	}
 
+	//state tracking is more precise with these
+	irc.RequestCap(CapMultiPrefix, CapUserhostNames, CapAwayNotify, CapChghost)
+
+	//services accounts of users for permissions
+	irc.RequestCap(CapAccountTag, CapAccountNotify, CapExtendedJoin)
+
+	//queries get precise replies with these
+	irc.RequestCap(CapBatch, CapLabeledResponse)
+
 	irc.AddHandler(defaultHandlers, nil)
 
 	return irc
@@ -79,6 +116,8 @@ func (irc *Connection) Connect() error {
 	if !irc.IsConnected {
 		var err error
 
+		irc.ErrorChan = make(chan error, 2)
+
 		if irc.restarting {
 			if irc.Secured {
 				log.Debugf("Reusing connection to tls://%s:%d", irc.Hostname, irc.Port)
@@ -86,14 +125,29 @@ func (irc *Connection) Connect() error {
 				log.Debugf("Reusing connection to tcp://%s:%d", irc.Hostname, irc.Port)
 			}
 		} else {
+			address := net.JoinHostPort(irc.Hostname, strconv.Itoa(irc.Port))
 			if irc.Secured {
-				log.Debugf("Connecting to tls://%s:%d", irc.Hostname, irc.Port)
-				irc.Socket, err = tls.Dial("tcp", fmt.Sprintf("%s:%d", irc.Hostname, irc.Port), nil)
+				log.Debugf("Connecting to tls://%s", address)
+				var config *tls.Config
+				config, err = irc.tlsConfig()
+				if err != nil {
+					return err
+				}
+				irc.Socket, err = tls.Dial("tcp", address, config)
 			} else {
-				log.Debugf("Connecting to tcp://%s:%d", irc.Hostname, irc.Port)
-				irc.Socket, err = net.Dial("tcp", fmt.Sprintf("%s:%d", irc.Hostname, irc.Port))
+				log.Debugf("Connecting to tcp://%s", address)
+				irc.Socket, err = net.Dial("tcp", address)
 			}
 			if err != nil {
+				var fingerprintErr *FingerprintError
+				if errors.As(err, &fingerprintErr) {
+					//report pin mismatch to whoever watches errors
+					select {
+					case irc.ErrorChan <- fingerprintErr:
+					default:
+					}
+					return fingerprintErr
+				}
 				return err
 			}
 		}
@@ -101,18 +155,18 @@ func (irc *Connection) Connect() error {
 		log.Infof("Connected to %s (%s)", irc.Hostname, irc.Socket.RemoteAddr())
 
 		irc.write = make(chan string, 1024)
+		irc.written = make(chan struct{})
 		irc.exit = make(chan struct{})
-		irc.ErrorChan = make(chan error, 2)
 
 		irc.lastMessage = ""
 		irc.lastMessageTime = time.Now()
 		irc.lastsent = time.Now()
-		irc.currentNickname = irc.Nickname
+		irc.setCurrentNick(irc.nickname())
 		irc.IsConnected = true
 
 		irc.wg.Add(3)
-		go irc.readLoop()
-		go irc.writeLoop()
+		go irc.readLoop(irc.Socket) //loops get socket, Disconnect clears it meanwhile
+		go irc.writeLoop(irc.Socket)
 		go irc.pingLoop()
 
 		//take care of the inital flush
@@ -133,6 +187,8 @@ func (irc *Connection) Disconnect() error {
 		irc.cleanUp()
 	}
 
+	irc.failQueries(ErrDisconnected)
+
 	if irc.IsConnected {
 		err := irc.Socket.Close()
 		irc.Socket = nil
@@ -165,7 +221,11 @@ func (irc *Connection) Reconnect() error {
 
 func (irc *Connection) Restart() {
 	irc.restarting = true
-	irc.cleanUp()
+	if irc.IsConnected {
+		close(irc.write)
+		<-irc.written //queued lines are sent before child takes over the socket
+	}
+	close(irc.exit)
 }
 
 //send raw irc message
@@ -180,9 +240,9 @@ func (irc *Connection) SendRawf(format string, a ...interface{}) {
 
 //loops
 
-func (irc *Connection) readLoop() {
+func (irc *Connection) readLoop(socket net.Conn) {
 	defer irc.wg.Done()
-	br := bufio.NewReaderSize(irc.Socket, 512)
+	br := bufio.NewReaderSize(socket, 512)
 	for {
 		select {
 		default:
@@ -201,32 +261,42 @@ func (irc *Connection) readLoop() {
 
 			log.Debugf("[RECV]<< %s", msg)
 
+			message := irc.parseIRCMessage(msg)
+
+			// Handle protocol things before anyone else sees the message
+			coreHandlers(message)
+
 			// Publish on broadcast channel
-			irc.broadcast.Write(irc.parseIRCMessage(msg))
+			irc.broadcast.Write(message)
 		case <-irc.exit:
 			return
 		}
 	}
 }
 
-func (irc *Connection) writeLoop() {
+func (irc *Connection) writeLoop(socket net.Conn) {
 	defer irc.wg.Done()
+	defer close(irc.written)
 	for {
 		select {
 		case b, ok := <-irc.write:
-			if !ok || b == "" || irc.Socket == nil {
+			if !ok || b == "" {
 				return
 			}
 
-			if t := irc.rateLimit(len(b)); t != 0 {
+			if t := irc.rateLimit(len(b)); t != 0 && !irc.NoFloodLimit {
 				// sleep for the current line's time value before sending it
 				log.Infof("Message flood! Sleeping for %.2f secs.", t.Seconds())
-				<-time.After(t)
+				select {
+				case <-time.After(t):
+				case <-irc.exit:
+					return
+				}
 			}
 
 			log.Debugf("[SEND]>> %s", strings.Trim(b, "\r\n"))
 
-			_, err := irc.Socket.Write([]byte(b))
+			_, err := socket.Write([]byte(b))
 			if err != nil {
 				irc.ErrorChan <- err
 				return
@@ -255,9 +325,8 @@ func (irc *Connection) pingLoop() {
 			irc.SendRawf("PING %d", time.Now().UnixNano())
 		case <-ticker60.C:
 			// Try to recapture nickname if it's not as configured.
-			if irc.Nickname != irc.currentNickname {
-				irc.currentNickname = irc.Nickname
-				irc.SendRawf("NICK %s", irc.Nickname)
+			if nick := irc.nickname(); nick != irc.CurrentNick() {
+				irc.Nick(nick)
 			}
 		case <-irc.exit:
 			// Shut down everything
@@ -290,13 +359,26 @@ func (irc *Connection) rateLimit(chars int) time.Duration {
 
 func (irc *Connection) postConnect() {
 	if irc.restarting {
-		irc.Nick(irc.Nickname) //try original nick
+		irc.refreshCaps()
+		irc.Nick(irc.nickname()) //try original nick
+
+		//rebuild server features and channel state
+		irc.SendRaw("VERSION")
+		irc.SendRawf("WHOIS %s", irc.CurrentNick())
 	} else {
+		irc.state.Reset()
+		irc.setFeatures(DefaultFeatures())
+
+		//registration is held by server until CAP END
+		irc.sasl.running = false
+		irc.wantSASL()
+		irc.startCapNegotiation()
+
 		if len(irc.Password) > 0 {
 			irc.SendRawf("PASS %s", irc.Password)
 		}
 
-		irc.Nick(irc.Nickname)
+		irc.Nick(irc.nickname())
 
 		realname := irc.RealName
 		if irc.RealName == "" {
@@ -310,16 +392,86 @@ func (irc *Connection) postConnect() {
 //irc commands
 
 func (irc *Connection) Nick(n string) {
-	irc.currentNickname = n
+	irc.setCurrentNick(n)
 	irc.SendRawf("NICK %s", n)
 }
 
+// SetNickname change's nickname the client will use, connected client ask's for it right away
+func (irc *Connection) SetNickname(n string) {
+	irc.nickLock.Lock()
+	irc.Nickname = n
+	irc.nickLock.Unlock()
+
+	if irc.IsConnected {
+		irc.Nick(n)
+	}
+}
+
+func (irc *Connection) nickname() string {
+	irc.nickLock.RLock()
+	defer irc.nickLock.RUnlock()
+	return irc.Nickname
+}
+
+// CurrentNick return's nick we have on server
 func (irc *Connection) CurrentNick() string {
+	irc.state.RLock()
+	defer irc.state.RUnlock()
 	return irc.currentNickname
 }
 
+func (irc *Connection) setCurrentNick(n string) {
+	irc.state.Lock()
+	irc.currentNickname = n
+	irc.state.Unlock()
+}
+
+// Features return's copy of features supported by server
+func (irc *Connection) Features() ServerFeatures {
+	irc.featuresLock.RLock()
+	defer irc.featuresLock.RUnlock()
+	return irc.features
+}
+
+func (irc *Connection) setFeatures(f ServerFeatures) {
+	irc.featuresLock.Lock()
+	irc.features = f
+	irc.featuresLock.Unlock()
+	irc.state.setFeatures(f)
+}
+
+// handleISupport parses RPL_ISUPPORT: nick TOKEN TOKEN=value :are supported by this server
+func (irc *Connection) handleISupport(event *Message) {
+	if len(event.Arguments) < 3 {
+		return
+	}
+	f := irc.Features()
+	f.Parse(event.Arguments[1 : len(event.Arguments)-1]...)
+	irc.setFeatures(f)
+}
+
+// checkTargets validates comma separated targets of command
+func (irc *Connection) checkTargets(command, targets string, valid func(ServerFeatures, string) bool) error {
+	f := irc.Features()
+	list := strings.Split(targets, ",")
+	if max := f.MaxTargets(command); max > 0 && len(list) > max {
+		return fmt.Errorf("Too many targets for %s (%d, max %d)!", command, len(list), max)
+	}
+	for _, target := range list {
+		if !valid(f, target) {
+			return fmt.Errorf("Invalid %s target \"%s\"!", command, target)
+		}
+	}
+	return nil
+}
+
+// State return's tracked channels and users
+func (irc *Connection) State() *State {
+	return irc.state
+}
+
 func (irc *Connection) GetNick() string {
-	return irc.currentNickname
+	return irc.CurrentNick()
 }
 
 func (irc *Connection) Quit() {
@@ -330,28 +482,49 @@ func (irc *Connection) QuitWithMessage(message string) {
 	irc.SendRawf("QUIT :%s", message)
 }
 
-func (irc *Connection) Join(channel string) {
+func (irc *Connection) Join(channel string) error {
+	channels := strings.SplitN(channel, " ", 2)[0] //channel list may be followed by keys
+	if err := irc.checkTargets("JOIN", channels, ServerFeatures.ValidChannel); err != nil {
+		log.Error(err)
+		return err
+	}
 	irc.SendRawf("JOIN %s", channel)
+	return nil
 }
 
-func (irc *Connection) Part(channel string) {
+func (irc *Connection) Part(channel string) error {
+	if err := irc.checkTargets("PART", channel, ServerFeatures.ValidChannel); err != nil {
+		log.Error(err)
+		return err
+	}
 	irc.SendRawf("PART %s", channel)
+	return nil
 }
 
-func (irc *Connection) Notice(target, message string) {
-	irc.SendRawf("NOTICE %s :%s", target, message)
+func (irc *Connection) Notice(target, message string) error {
+	if err := irc.checkTargets("NOTICE", target, ServerFeatures.ValidTarget); err != nil {
+		log.Error(err)
+		return err
+	}
+	irc.sendSplit("NOTICE", target, "", "", message)
+	return nil
 }
 
-func (irc *Connection) Noticef(target, format string, a ...interface{}) {
-	irc.Notice(target, fmt.Sprintf(format, a...))
+func (irc *Connection) Noticef(target, format string, a ...interface{}) error {
+	return irc.Notice(target, fmt.Sprintf(format, a...))
 }
 
-func (irc *Connection) Privmsg(target, message string) {
-	irc.SendRawf("PRIVMSG %s :%s", target, message)
+func (irc *Connection) Privmsg(target, message string) error {
+	if err := irc.checkTargets("PRIVMSG", target, ServerFeatures.ValidTarget); err != nil {
+		log.Error(err)
+		return err
+	}
+	irc.sendSplit("PRIVMSG", target, "", "", message)
+	return nil
 }
 
-func (irc *Connection) Privmsgf(target, format string, a ...interface{}) {
-	irc.Privmsg(target, fmt.Sprintf(format, a...))
+func (irc *Connection) Privmsgf(target, format string, a ...interface{}) error {
+	return irc.Privmsg(target, fmt.Sprintf(format, a...))
 }
 
 func (irc *Connection) Ctcp(target, message string) {
@@ -371,7 +544,11 @@ func (irc *Connection) Ctcpnf(target, format string, a ...interface{}) {
 }
 
 func (irc *Connection) Action(target, message string) {
-	irc.Ctcp(target, "ACTION "+message)
+	if err := irc.checkTargets("PRIVMSG", target, ServerFeatures.ValidTarget); err != nil {
+		log.Error(err)
+		return
+	}
+	irc.sendSplit("PRIVMSG", target, "\x01ACTION ", "\x01", message)
 }
 
 func (irc *Connection) Actionf(target, format string, a ...interface{}) {
@@ -2,20 +2,51 @@ package irc
 
 import (
 	log "github.com/Sirupsen/logrus"
-	"github.com/natrim/grainbot/permissions"
+	"github.com/natrim/grainbot/broadcast"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Message struct {
-	Raw              string // Raw message string
+	Raw              string            // Raw message string
+	Tags             map[string]string // IRCv3 message tags (unescaped)
 	Prefix           string
 	Command          string
 	Arguments        []string
 	Server           *Connection
 	Channel          string
 	Nick, User, Host string
+	Received         time.Time // time of message receive
+}
+
+// Tag return's value of message tag and if the tag is present
+func (m *Message) Tag(name string) (string, bool) {
+	value, ok := m.Tags[name]
+	return value, ok
+}
+
+// ServerTime return's time from server-time tag or the time of receive
+func (m *Message) ServerTime() time.Time {
+	if value, ok := m.Tags["time"]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t
+		}
+	}
+	return m.Received
+}
+
+// Account return's services account name of sender from account tag or tracked state, empty if unknown
+func (m *Message) Account() string {
+	if account, ok := m.Tags["account"]; ok {
+		return account
+	}
+	if m.Server != nil && m.Nick != "" {
+		if user := m.Server.State().User(m.Nick); user != nil {
+			return user.Account
+		}
+	}
+	return ""
 }
 
 func (m *Message) Action(message string) {
@@ -68,6 +99,18 @@ func (m *Message) Mentionf(message string, a ...interface{}) {
 
 //raw irc string parsing
 func (irc *Connection) parseIRCMessage(msg string) *Message {
+	message := ParseMessage(msg)
+	message.Server = irc
+
+	if len(message.Arguments) > 0 && irc.Features().IsChannel(message.Arguments[0]) {
+		message.Channel = message.Arguments[0]
+	}
+
+	return message
+}
+
+// ParseMessage parses raw irc line including IRCv3 message tags
+func ParseMessage(msg string) *Message {
 	// http://twistedmatrix.com/trac/browser/trunk/twisted/words/protocols/irc.py#54
 	prefix := ""
 	trailing := ""
@@ -76,11 +119,27 @@ func (irc *Connection) parseIRCMessage(msg string) *Message {
 	user := ""
 	host := ""
 	args := []string{}
-	s := msg
+	tags := map[string]string{}
+	s := strings.TrimLeft(msg, " ")
 
-	if s[0] == ':' {
+	if len(s) > 0 && s[0] == '@' {
 		splits := strings.SplitN(s[1:], " ", 2)
-		prefix, s = splits[0], splits[1]
+		tags = parseTags(splits[0])
+		if len(splits) > 1 {
+			s = strings.TrimLeft(splits[1], " ")
+		} else {
+			s = ""
+		}
+	}
+
+	if len(s) > 0 && s[0] == ':' {
+		splits := strings.SplitN(s[1:], " ", 2)
+		prefix = splits[0]
+		if len(splits) > 1 {
+			s = splits[1]
+		} else {
+			s = ""
+		}
 	}
 
 	if strings.Contains(s, " :") {
@@ -91,43 +150,121 @@ func (irc *Connection) parseIRCMessage(msg string) *Message {
 	} else {
 		args = strings.Fields(s)
 	}
-	command, args = args[0], args[1:]
+	if len(args) > 0 {
+		command, args = strings.ToUpper(args[0]), args[1:]
+	}
 
-	if i, j := strings.Index(prefix, "!"), strings.Index(prefix, "@"); i > -1 && j > -1 {
+	if i, j := strings.Index(prefix, "!"), strings.Index(prefix, "@"); i > -1 && j > i {
 		nick = prefix[0:i]
 		user = prefix[i+1 : j]
-		host = prefix[j+1 : len(prefix)]
-	}
-
-	channel := ""
-	if args[0] != irc.currentNickname {
-		channel = args[0]
+		host = prefix[j+1:]
 	}
 
 	return &Message{
 		Raw:       msg,
+		Tags:      tags,
 		Prefix:    prefix,
 		Command:   command,
 		Arguments: args,
-		Channel:   channel,
-		Server:    irc,
 		Nick:      nick,
 		User:      user,
 		Host:      host,
+		Received:  time.Now(),
 	}
 }
 
-func (irc *Connection) AddHandler(f func(*Message), permission permissions.Permission) chan bool {
-	messages := irc.broadcast.Listen(1024)
+// parseTags parses the tag part of message (without the leading @)
+func parseTags(raw string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = UnescapeTagValue(kv[1])
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
+
+// UnescapeTagValue unescapes message tag value by IRCv3 rules
+func UnescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	buf := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf = append(buf, value[i])
+			continue
+		}
+		i++
+		if i >= len(value) { //trailing backslash is dropped
+			break
+		}
+		switch value[i] {
+		case ':':
+			buf = append(buf, ';')
+		case 's':
+			buf = append(buf, ' ')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		default: //also handles \\
+			buf = append(buf, value[i])
+		}
+	}
+	return string(buf)
+}
+
+// EscapeTagValue escapes message tag value by IRCv3 rules
+func EscapeTagValue(value string) string {
+	return tagEscaper.Replace(value)
+}
+
+var tagEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n")
+
+// Validator decides if handler get's the message (implemented by permissions.Permission)
+type Validator interface {
+	Validate(m *Message) bool
+}
+
+// Ignorer decides if message should be hidden from handlers of scope (module name, empty for bot core)
+type Ignorer interface {
+	Ignored(m *Message, scope string) bool
+}
+
+func (irc *Connection) AddHandler(f func(*Message), permission Validator) chan bool {
+	return irc.AddScopedHandler("", f, permission)
+}
+
+// AddScopedHandler add's handler belonging to scope, scope is used by ignore list
+func (irc *Connection) AddScopedHandler(scope string, f func(*Message), permission Validator) chan bool {
+	//slow handler loses oldest messages instead of freezing whole bot
+	messages := irc.broadcast.ListenWithPolicy(1024, broadcast.DropOldest)
 	killchan := make(chan bool)
 	go func() {
+		defer irc.broadcast.Unlisten(messages)
+		var dropped uint64
 		for {
 			select {
 			case k := <-killchan:
 				if k {
 					return
 				}
-			case e := <-messages:
+			case e, ok := <-messages:
+				if !ok {
+					return
+				}
+				if n := irc.broadcast.Dropped(messages); n > dropped {
+					log.Warnf("Handler of \"%s\" is too slow, %d messages dropped!", scope, n-dropped)
+					dropped = n
+				}
 				func() {
 					defer func() {
 						if r := recover(); r != nil {
@@ -135,8 +272,11 @@ func (irc *Connection) AddHandler(f func(*Message), permission permissions.Permi
 						}
 					}()
 					event := e.(*Message)
+					if irc.Ignore != nil && (event.Command == "PRIVMSG" || event.Command == "NOTICE") && irc.Ignore.Ignored(event, scope) {
+						return
+					}
 					if permission != nil {
-						if ok := permission.Validate(event.Nick, event.User, event.Host); ok {
+						if ok := permission.Validate(event); ok {
 							f(event)
 						}
 					} else {
@@ -149,21 +289,50 @@ func (irc *Connection) AddHandler(f func(*Message), permission permissions.Permi
 	return killchan
 }
 
-func defaultHandlers(event *Message) {
+// coreHandlers run synchronously in read loop before the message is broadcasted
+func coreHandlers(event *Message) {
 	irc := event.Server
 
 	switch event.Command {
-	case "PING":
-		irc.SendRawf("PONG %s", event.Arguments[len(event.Arguments)-1])
+	case "CAP":
+		irc.handleCap(event)
+
+	case "AUTHENTICATE", "900", "903", "904", "905", "906", "907", "908":
+		irc.handleSASL(event)
+
+	case "001":
+		irc.finishCapNegotiation()
+
+	case "005":
+		irc.handleISupport(event)
+
+	case "JOIN", "PART", "KICK", "QUIT", "NICK", "MODE", "TOPIC", "AWAY", "CHGHOST", "ACCOUNT",
+		"319", "324", "331", "332", "333", "352", "353", "354", "366":
+		irc.handleState(event)
 
-	case "433", "437":
-		irc.currentNickname = irc.currentNickname + "_"
-		irc.Nick(irc.currentNickname)
+	case "433", "437": //nick in use, try another one right away
+		irc.Nick(irc.CurrentNick() + "_")
 
-	case "NICK":
-		if event.Nick == irc.currentNickname {
-			irc.currentNickname = event.Arguments[0]
+	case "410", "421":
+		if len(event.Arguments) > 1 && strings.ToUpper(event.Arguments[1]) == "CAP" {
+			irc.finishCapNegotiation()
 		}
+	}
+
+	if _, ok := event.Tags["account"]; ok && event.Nick != "" {
+		irc.handleAccountTag(event)
+	}
+
+	irc.dispatchQuery(event)
+}
+
+func defaultHandlers(event *Message) {
+	irc := event.Server
+	features := irc.Features()
+
+	switch event.Command {
+	case "PING":
+		irc.SendRawf("PONG %s", event.Arguments[len(event.Arguments)-1])
 
 	case "PONG":
 		ns, _ := strconv.ParseInt(event.Arguments[1], 10, 64)
@@ -171,7 +340,7 @@ func defaultHandlers(event *Message) {
 		log.Infof("Lag: %v", delta)
 
 	case "PRIVMSG", "NOTICE":
-		if event.Arguments[0] == irc.currentNickname && len(event.Arguments[1]) > 2 && strings.HasPrefix(event.Arguments[1], "\x01") && strings.HasSuffix(event.Arguments[1], "\x01") { //ctcp
+		if features.EqualFold(event.Arguments[0], irc.CurrentNick()) && len(event.Arguments[1]) > 2 && strings.HasPrefix(event.Arguments[1], "\x01") && strings.HasSuffix(event.Arguments[1], "\x01") { //ctcp
 			ctcp := strings.Trim(event.Arguments[1], "\x01")
 			parts := strings.Split(ctcp, " ")
 
@@ -0,0 +1,61 @@
+package irc_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/natrim/grainbot/irc"
+)
+
+func TestParseMessage(t *testing.T) {
+	m := ParseMessage(":Pinkie!pie@sugar.cube PRIVMSG #pony :hello there")
+
+	if m.Command != "PRIVMSG" || m.Nick != "Pinkie" || m.User != "pie" || m.Host != "sugar.cube" {
+		t.Fatalf("Wrong prefix parse: %#v", m)
+	}
+
+	if len(m.Arguments) != 2 || m.Arguments[0] != "#pony" || m.Arguments[1] != "hello there" {
+		t.Fatalf("Wrong arguments parse: %#v", m.Arguments)
+	}
+
+	if len(m.Tags) != 0 {
+		t.Fatalf("Untagged message has tags: %#v", m.Tags)
+	}
+}
+
+func TestParseMessageTags(t *testing.T) {
+	m := ParseMessage(`@time=2011-10-19T16:40:51.620Z;account=dashie;+example.com/x=a\:b\sc\\d\r\n;empty= :Rainbow!dash@cloud PRIVMSG #pony :20% cooler`)
+
+	if m.Command != "PRIVMSG" || m.Nick != "Rainbow" {
+		t.Fatalf("Wrong tagged message parse: %#v", m)
+	}
+
+	if m.Account() != "dashie" {
+		t.Errorf("Wrong account: %q", m.Account())
+	}
+
+	if v, _ := m.Tag("+example.com/x"); v != "a;b c\\d\r\n" {
+		t.Errorf("Wrong unescape: %q", v)
+	}
+
+	if v, ok := m.Tag("empty"); !ok || v != "" {
+		t.Errorf("Wrong empty tag: %q %v", v, ok)
+	}
+
+	expected := time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC)
+	if !m.ServerTime().Equal(expected) {
+		t.Errorf("Wrong server time: %s", m.ServerTime())
+	}
+}
+
+func TestTagEscaping(t *testing.T) {
+	for _, value := range []string{"", "plain", "semi;colon", "space here", `back\slash`, "cr\rlf\n", `trailing\`} {
+		if got := UnescapeTagValue(EscapeTagValue(value)); got != value {
+			t.Errorf("Round trip of %q failed: %q", value, got)
+		}
+	}
+
+	if got := UnescapeTagValue(`dangling\`); got != "dangling" {
+		t.Errorf("Trailing backslash not dropped: %q", got)
+	}
+}
@@ -0,0 +1,157 @@
+// Package irctest provides fake irc server for tests of bot and its modules
+package irctest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/irc"
+)
+
+// Server is the other end of bot connection
+type Server struct {
+	conn     net.Conn
+	lines    chan string
+	listener *net.TCPListener //set when bot dials the server itself
+}
+
+// NewConnection return's bot connection without flood protection
+func NewConnection() *irc.Connection {
+	conn := irc.NewConnection("dashy", "grainbot", "Botus Grainus")
+	conn.NoFloodLimit = true
+	return conn
+}
+
+// NewServer return's bot connected to fake server
+func NewServer(t testing.TB) (*irc.Connection, *Server) {
+	conn := NewConnection()
+	return conn, Connect(t, conn)
+}
+
+// Connect connects bot to fake server as after restart (without registration)
+func Connect(t testing.TB, conn *irc.Connection) *Server {
+	client, server := net.Pipe()
+
+	fake := &Server{}
+	fake.serve(server)
+
+	if err := conn.ConnectTo(client); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake
+}
+
+// Register return's bot which dials fake server and registers (with CAP negotiation),
+// setup is called before connecting
+func Register(t testing.TB, setup func(*irc.Connection)) (*irc.Connection, *Server) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &Server{listener: listener}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	conn := NewConnection()
+	conn.Hostname = host
+	conn.Port, _ = strconv.Atoi(port)
+	if setup != nil {
+		setup(conn)
+	}
+
+	if err := conn.Connect(); err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+	fake.Accept(t)
+
+	return conn, fake
+}
+
+// Accept wait's for next connection of bot (eg. after Reconnect)
+func (s *Server) Accept(t testing.TB) {
+	s.listener.SetDeadline(time.Now().Add(time.Second))
+	conn, err := s.listener.Accept()
+	if err != nil {
+		t.Fatalf("Bot did not connect: %v", err)
+	}
+	s.serve(conn)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	if s.conn != nil {
+		s.conn.Close() //previous connection of bot
+	}
+	lines := make(chan string, 1024)
+	s.conn, s.lines = conn, lines
+	go func() {
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+}
+
+// Close closes connection (and listener)
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.conn.Close()
+}
+
+// Send writes lines to bot
+func (s *Server) Send(lines ...string) {
+	for _, line := range lines {
+		s.conn.Write([]byte(line + "\r\n"))
+	}
+}
+
+// Wait return's first line sent by bot starting with any of prefixes, other lines are skipped
+func (s *Server) Wait(timeout time.Duration, prefixes ...string) (string, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				return "", false
+			}
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(line, prefix) {
+					return line, true
+				}
+			}
+		case <-deadline:
+			return "", false
+		}
+	}
+}
+
+// Expect wait's for line sent by bot starting with prefix
+func (s *Server) Expect(t testing.TB, prefix string) string {
+	line, ok := s.Wait(time.Second, prefix)
+	if !ok {
+		t.Fatalf("Bot did not send %q", prefix)
+	}
+	return line
+}
+
+// Eventually wait's until condition is true
+func Eventually(t testing.TB, what string, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Condition not met: %s", what)
+}
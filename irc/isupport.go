@@ -0,0 +1,227 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ServerFeatures is the parsed RPL_ISUPPORT (005) of the server
+type ServerFeatures struct {
+	Network       string
+	CaseMapping   string         //rfc1459, strict-rfc1459 or ascii
+	ChanTypes     string         //channel prefixes, eg. "#&"
+	PrefixModes   string         //modes giving user prefixes, eg. "ov"
+	PrefixSymbols string         //prefix symbols, eg. "@+"
+	ChanModes     [4]string      //CHANMODES groups: list, always param, param when set, no param
+	StatusMsg     string         //prefixes allowed before channel in PRIVMSG/NOTICE
+	NickLen       int            //max nick length, 0 for unknown
+	ChannelLen    int            //max channel length, 0 for unknown
+	TopicLen      int            //max topic length, 0 for unlimited
+	LineLen       int            //max line length in bytes including CRLF
+	TargMax       map[string]int //max targets per command, 0 for unlimited
+	Tokens        map[string]string
+
+	fold func(string) string
+}
+
+// DefaultFeatures return's RFC 1459 features used before the server tells us more
+func DefaultFeatures() ServerFeatures {
+	f := ServerFeatures{
+		CaseMapping:   "rfc1459",
+		ChanTypes:     "#&",
+		PrefixModes:   "ov",
+		PrefixSymbols: "@+",
+		ChanModes:     [4]string{"b", "k", "l", "imnpst"},
+		LineLen:       512,
+		TargMax:       map[string]int{},
+		Tokens:        map[string]string{},
+	}
+	f.fold = caseMappings[f.CaseMapping]
+	return f
+}
+
+var caseMappings = map[string]func(string) string{
+	"rfc1459": FoldRFC1459,
+	"strict-rfc1459": func(s string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z':
+				return r + 32
+			case r == '[':
+				return '{'
+			case r == ']':
+				return '}'
+			case r == '\\':
+				return '|'
+			}
+			return r
+		}, s)
+	},
+	"ascii": func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + 32
+			}
+			return r
+		}, s)
+	},
+	"rfc7613": strings.ToLower,
+}
+
+// Parse applies ISUPPORT tokens (eg. "CHANTYPES=#", "-EXCEPTS") onto features
+func (f *ServerFeatures) Parse(tokens ...string) {
+	//maps are replaced, not modified, so copies of features stay untouched
+	raw := make(map[string]string, len(f.Tokens)+len(tokens))
+	for k, v := range f.Tokens {
+		raw[k] = v
+	}
+
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		if token[0] == '-' {
+			delete(raw, strings.ToUpper(token[1:]))
+			continue
+		}
+		kv := strings.SplitN(token, "=", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = unescapeISupport(kv[1])
+		}
+		raw[strings.ToUpper(kv[0])] = value
+	}
+
+	defaults := DefaultFeatures()
+	*f = defaults
+	f.Tokens = raw
+
+	if v, ok := raw["NETWORK"]; ok {
+		f.Network = v
+	}
+	if v, ok := raw["CASEMAPPING"]; ok {
+		if _, known := caseMappings[strings.ToLower(v)]; known {
+			f.CaseMapping = strings.ToLower(v)
+		} else {
+			f.CaseMapping = "ascii"
+		}
+	}
+	if v, ok := raw["CHANTYPES"]; ok {
+		f.ChanTypes = v
+	}
+	if v, ok := raw["PREFIX"]; ok {
+		if i := strings.Index(v, ")"); strings.HasPrefix(v, "(") && i > 0 && len(v)-i-1 == i-1 {
+			f.PrefixModes, f.PrefixSymbols = v[1:i], v[i+1:]
+		} else if v == "" {
+			f.PrefixModes, f.PrefixSymbols = "", ""
+		}
+	}
+	if v, ok := raw["CHANMODES"]; ok {
+		groups := strings.Split(v, ",")
+		for i := 0; i < len(groups) && i < len(f.ChanModes); i++ {
+			f.ChanModes[i] = groups[i]
+		}
+	}
+	if v, ok := raw["STATUSMSG"]; ok {
+		f.StatusMsg = v
+	}
+	f.NickLen = isupportInt(raw, "NICKLEN", f.NickLen)
+	f.ChannelLen = isupportInt(raw, "CHANNELLEN", f.ChannelLen)
+	f.TopicLen = isupportInt(raw, "TOPICLEN", f.TopicLen)
+	f.LineLen = isupportInt(raw, "LINELEN", f.LineLen)
+	if v, ok := raw["TARGMAX"]; ok {
+		for _, target := range strings.Split(v, ",") {
+			kv := strings.SplitN(target, ":", 2)
+			if len(kv) == 2 {
+				max, _ := strconv.Atoi(kv[1]) //empty means unlimited
+				f.TargMax[strings.ToUpper(kv[0])] = max
+			}
+		}
+	}
+
+	f.fold = caseMappings[f.CaseMapping]
+}
+
+func isupportInt(raw map[string]string, key string, def int) int {
+	if v, ok := raw[key]; ok {
+		if i, err := strconv.Atoi(v); err == nil && i > 0 {
+			return i
+		}
+	}
+	return def
+}
+
+// unescapeISupport decodes \xHH escapes in token values
+func unescapeISupport(value string) string {
+	if !strings.Contains(value, "\\x") {
+		return value
+	}
+	var buf []byte
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if b, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				buf = append(buf, byte(b))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, value[i])
+	}
+	return string(buf)
+}
+
+// Fold lowercases nick or channel by server case mapping
+func (f ServerFeatures) Fold(s string) string {
+	if f.fold == nil {
+		return FoldRFC1459(s)
+	}
+	return f.fold(s)
+}
+
+// EqualFold compares nicks or channels by server case mapping
+func (f ServerFeatures) EqualFold(a, b string) bool {
+	return f.Fold(a) == f.Fold(b)
+}
+
+// IsChannel return's true if target is channel name
+func (f ServerFeatures) IsChannel(target string) bool {
+	return target != "" && strings.IndexByte(f.ChanTypes, target[0]) > -1
+}
+
+// MaxTargets return's max targets for command, 0 for unlimited
+func (f ServerFeatures) MaxTargets(command string) int {
+	return f.TargMax[strings.ToUpper(command)]
+}
+
+// ValidNick checks if nick is syntactically valid
+func (f ServerFeatures) ValidNick(nick string) bool {
+	if nick == "" || (f.NickLen > 0 && len(nick) > f.NickLen) || f.IsChannel(nick) {
+		return false
+	}
+	if strings.IndexByte(f.PrefixSymbols, nick[0]) > -1 || nick[0] == ':' || nick[0] == '$' {
+		return false
+	}
+	return !strings.ContainsAny(nick, " ,*?!@\x00\r\n")
+}
+
+// ValidChannel checks if channel name is syntactically valid
+func (f ServerFeatures) ValidChannel(channel string) bool {
+	if !f.IsChannel(channel) || (f.ChannelLen > 0 && len(channel) > f.ChannelLen) {
+		return false
+	}
+	return !strings.ContainsAny(channel, " ,\x07\x00\r\n")
+}
+
+// ValidTarget checks if target is valid channel (with optional STATUSMSG prefix) or nick
+func (f ServerFeatures) ValidTarget(target string) bool {
+	if target != "" && strings.IndexByte(f.StatusMsg, target[0]) > -1 && f.IsChannel(target[1:]) {
+		target = target[1:]
+	}
+	if f.IsChannel(target) {
+		return f.ValidChannel(target)
+	}
+	if i := strings.Index(target, "@"); i > 0 { //nick@server
+		target = target[:i]
+	}
+	return f.ValidNick(target)
+}
@@ -0,0 +1,69 @@
+package irc_test
+
+import (
+	"testing"
+
+	. "github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestServerFeatures(t *testing.T) {
+	f := DefaultFeatures()
+
+	if !f.EqualFold("Dash[1]", "dash{1}") || !f.IsChannel("&local") {
+		t.Fatal("Wrong RFC 1459 defaults")
+	}
+
+	f.Parse("NETWORK=Equestria\\x20Net", "CASEMAPPING=ascii", "CHANTYPES=#", "PREFIX=(qaohv)~&@%+",
+		"CHANMODES=beI,k,l,imnpst", "NICKLEN=16", "TARGMAX=PRIVMSG:4,NOTICE:4,JOIN:")
+
+	if f.Network != "Equestria Net" {
+		t.Errorf("Wrong escaped network name: %q", f.Network)
+	}
+	if f.EqualFold("Dash[1]", "dash{1}") || !f.EqualFold("DASH", "dash") {
+		t.Error("CASEMAPPING not applied")
+	}
+	if f.IsChannel("&local") || !f.IsChannel("#pony") {
+		t.Error("CHANTYPES not applied")
+	}
+	if f.PrefixModes != "qaohv" || f.PrefixSymbols != "~&@%+" || f.ChanModes[0] != "beI" {
+		t.Error("PREFIX or CHANMODES not applied")
+	}
+	if f.MaxTargets("privmsg") != 4 || f.MaxTargets("JOIN") != 0 {
+		t.Error("TARGMAX not applied")
+	}
+	if f.ValidNick("TwilightSparkle42") || !f.ValidNick("Twilight") || f.ValidNick("@Twilight") {
+		t.Error("Wrong nick validation")
+	}
+
+	f.Parse("-NETWORK")
+	if f.Network != "" || f.NickLen != 16 {
+		t.Error("Negated token not removed")
+	}
+}
+
+func TestISupportInConnection(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	server.Send(":irc.server 005 dashy CHANTYPES=# PREFIX=(qov)~@+ CASEMAPPING=ascii :are supported by this server")
+	irctest.Eventually(t, "features parsed", func() bool { return conn.Features().CaseMapping == "ascii" })
+
+	if err := conn.Join("&local"); err == nil {
+		t.Error("Invalid channel joined")
+	}
+	if err := conn.Privmsg("bad nick", "hi"); err == nil {
+		t.Error("Message to invalid target sent")
+	}
+
+	server.Send(
+		":dashy!grainbot@bot.host JOIN #pony",
+		":irc.server 353 dashy = #pony :dashy ~Celestia",
+		":irc.server 366 dashy #pony :End of /NAMES list.",
+	)
+	irctest.Eventually(t, "channel joined", func() bool { return len(conn.State().Users("#pony")) == 2 })
+
+	if !conn.State().IsOp("#pony", "celestia") {
+		t.Error("Founder prefix not treated as op")
+	}
+}
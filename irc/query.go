@@ -0,0 +1,475 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDisconnected is returned by queries waiting when connection closes
+var ErrDisconnected = errors.New("Disconnected!")
+
+// QueryError is error numeric received as reply to query
+type QueryError struct {
+	Code    string
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WhoisReply is collected reply to WHOIS
+type WhoisReply struct {
+	Nick       string
+	User       string
+	Host       string
+	RealName   string
+	Server     string
+	ServerInfo string
+	Account    string
+	Away       string
+	Channels   []string //with prefixes, eg. "@#pony"
+	Idle       time.Duration
+	SignOn     time.Time
+	Operator   bool
+	Secure     bool
+}
+
+// WhoReply is one line of reply to WHO
+type WhoReply struct {
+	Channel  string
+	User     string
+	Host     string
+	Server   string
+	Nick     string
+	Flags    string //H/G, * for oper, prefixes
+	Hops     int
+	RealName string
+	Account  string
+}
+
+// Away return's true if user is marked as away
+func (w WhoReply) Away() bool {
+	return strings.HasPrefix(w.Flags, "G")
+}
+
+// NamesReply is one user in reply to NAMES
+type NamesReply struct {
+	Nick     string
+	Prefixes string
+}
+
+// ListReply is one channel in reply to LIST
+type ListReply struct {
+	Channel string
+	Users   int
+	Topic   string
+}
+
+// whoxToken marks our WHOX requests, replies have fields in order of whoxFields
+const (
+	whoxToken  = "616"
+	whoxFields = "%tcuhsnfdar," + whoxToken
+)
+
+// whoCommand return's WHO command for mask, asking also for accounts when server supports WHOX
+func (irc *Connection) whoCommand(mask string) string {
+	if _, ok := irc.Features().Tokens["WHOX"]; ok {
+		return "WHO " + mask + " " + whoxFields
+	}
+	return "WHO " + mask
+}
+
+// parseWho parses RPL_WHOREPLY (352) or reply to our WHOX (354)
+func parseWho(m *Message) (WhoReply, bool) {
+	a := m.Arguments
+	switch m.Command {
+	case "352": //me #channel user host server nick flags :hops realname
+		if len(a) < 8 {
+			return WhoReply{}, false
+		}
+		reply := WhoReply{Channel: a[1], User: a[2], Host: a[3], Server: a[4], Nick: a[5], Flags: a[6]}
+		parts := strings.SplitN(a[7], " ", 2)
+		reply.Hops, _ = strconv.Atoi(parts[0])
+		if len(parts) == 2 {
+			reply.RealName = parts[1]
+		}
+		return reply, true
+
+	case "354": //me token #channel user host server nick flags hops account :realname
+		if len(a) < 11 || a[1] != whoxToken {
+			return WhoReply{}, false
+		}
+		reply := WhoReply{Channel: a[2], User: a[3], Host: a[4], Server: a[5], Nick: a[6], Flags: a[7], RealName: a[10]}
+		reply.Hops, _ = strconv.Atoi(a[8])
+		if a[9] != "0" { //0 is not logged in
+			reply.Account = a[9]
+		}
+		return reply, true
+	}
+	return WhoReply{}, false
+}
+
+// query is request waiting for its numeric replies
+type query struct {
+	label string //labeled-response label
+	batch string //labeled-response batch reference
+	err   error  //error numeric received before end
+
+	accept func(*Message) bool //does message without label belong to query
+	handle func(*Message) bool //collect's reply, return's true on end
+	done   chan error
+}
+
+// finish end's query with error (or nil)
+func (q *query) finish(err error) {
+	select {
+	case q.done <- err:
+	default:
+	}
+}
+
+func (irc *Connection) addQuery(q *query) {
+	irc.queriesLock.Lock()
+	irc.queries = append(irc.queries, q)
+	irc.queriesLock.Unlock()
+}
+
+func (irc *Connection) removeQuery(q *query) {
+	irc.queriesLock.Lock()
+	defer irc.queriesLock.Unlock()
+	for i, query := range irc.queries {
+		if query == q {
+			irc.queries = append(irc.queries[:i], irc.queries[i+1:]...)
+			return
+		}
+	}
+}
+
+// failQueries end's all waiting queries
+func (irc *Connection) failQueries(err error) {
+	irc.queriesLock.Lock()
+	queries := irc.queries
+	irc.queries = nil
+	irc.queriesLock.Unlock()
+
+	for _, q := range queries {
+		q.finish(err)
+	}
+}
+
+// runQuery send's command and wait's for query end or context cancel
+func (irc *Connection) runQuery(ctx context.Context, q *query, command string) error {
+	if !irc.IsConnected {
+		return errors.New("Not connected!")
+	}
+
+	q.done = make(chan error, 1)
+	if irc.HasCap(CapLabeledResponse) {
+		q.label = strconv.FormatUint(uint64(atomic.AddUint32(&irc.labels, 1)), 36)
+		command = "@label=" + q.label + " " + command
+	}
+
+	irc.addQuery(q)
+	irc.SendRaw(command)
+
+	select {
+	case err := <-q.done:
+		irc.removeQuery(q)
+		return err
+	case <-ctx.Done():
+		irc.removeQuery(q)
+		return ctx.Err()
+	}
+}
+
+// dispatchQuery hand's message to query waiting for it
+func (irc *Connection) dispatchQuery(event *Message) {
+	irc.queriesLock.Lock()
+	if len(irc.queries) == 0 {
+		irc.queriesLock.Unlock()
+		return
+	}
+
+	var q *query
+	labeled := false
+	if label, ok := event.Tags["label"]; ok {
+		for _, query := range irc.queries {
+			if query.label == label {
+				q, labeled = query, true
+				break
+			}
+		}
+		if q != nil && event.Command == "BATCH" && len(event.Arguments) > 0 && strings.HasPrefix(event.Arguments[0], "+") {
+			q.batch = event.Arguments[0][1:] //replies follow in batch
+			irc.queriesLock.Unlock()
+			return
+		}
+	} else if ref, ok := event.Tags["batch"]; ok {
+		for _, query := range irc.queries {
+			if query.batch != "" && query.batch == ref {
+				q = query
+				break
+			}
+		}
+	} else if event.Command == "BATCH" && len(event.Arguments) > 0 && strings.HasPrefix(event.Arguments[0], "-") {
+		for _, query := range irc.queries {
+			if query.batch != "" && query.batch == event.Arguments[0][1:] {
+				irc.queriesLock.Unlock()
+				query.finish(query.err)
+				return
+			}
+		}
+	} else {
+		for _, query := range irc.queries {
+			if query.label == "" && query.accept(event) {
+				q = query
+				break
+			}
+		}
+	}
+	irc.queriesLock.Unlock()
+
+	if q == nil {
+		return
+	}
+
+	if q.handle(event) || (labeled && q.batch == "") { //single labeled message is whole reply
+		q.finish(q.err)
+	}
+}
+
+// queryError creates error from error numeric
+func queryError(event *Message) error {
+	return &QueryError{Code: event.Command, Message: event.Arguments[len(event.Arguments)-1]}
+}
+
+// Whois asks server about user
+func (irc *Connection) Whois(ctx context.Context, nick string) (*WhoisReply, error) {
+	reply := &WhoisReply{Nick: nick}
+	f := irc.Features()
+
+	q := &query{}
+	q.accept = func(m *Message) bool {
+		switch m.Command {
+		case "301", "307", "311", "312", "313", "317", "318", "319", "320", "330", "338", "378", "379", "401", "402", "671":
+			return len(m.Arguments) > 1 && f.EqualFold(m.Arguments[1], nick)
+		}
+		return false
+	}
+	q.handle = func(m *Message) bool {
+		arg := func(i int) string {
+			if i < len(m.Arguments) {
+				return m.Arguments[i]
+			}
+			return ""
+		}
+		switch m.Command {
+		case "311":
+			reply.Nick, reply.User, reply.Host, reply.RealName = arg(1), arg(2), arg(3), arg(5)
+		case "312":
+			reply.Server, reply.ServerInfo = arg(2), arg(3)
+		case "313":
+			reply.Operator = true
+		case "317":
+			if idle, err := strconv.Atoi(arg(2)); err == nil {
+				reply.Idle = time.Duration(idle) * time.Second
+			}
+			if signon, err := strconv.ParseInt(arg(3), 10, 64); err == nil {
+				reply.SignOn = time.Unix(signon, 0)
+			}
+		case "319":
+			reply.Channels = append(reply.Channels, strings.Fields(arg(2))...)
+		case "330":
+			reply.Account = arg(2)
+		case "301":
+			reply.Away = arg(2)
+		case "671":
+			reply.Secure = true
+		case "401", "402":
+			q.err = queryError(m)
+			return m.Command == "402" //401 is followed by 318
+		case "318":
+			return true
+		}
+		return false
+	}
+
+	if err := irc.runQuery(ctx, q, "WHOIS "+nick); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Who asks server about users matching mask (nick, channel or mask), with accounts if server supports WHOX
+func (irc *Connection) Who(ctx context.Context, mask string) ([]WhoReply, error) {
+	var replies []WhoReply
+	f := irc.Features()
+
+	q := &query{}
+	q.accept = func(m *Message) bool {
+		if len(m.Arguments) < 2 {
+			return false
+		}
+		switch m.Command {
+		case "352", "354":
+			reply, ok := parseWho(m)
+			return ok && (!f.IsChannel(mask) || f.EqualFold(reply.Channel, mask))
+		case "315":
+			return f.EqualFold(m.Arguments[1], mask)
+		case "263":
+			return strings.EqualFold(m.Arguments[1], "WHO")
+		}
+		return false
+	}
+	q.handle = func(m *Message) bool {
+		switch m.Command {
+		case "352", "354":
+			if reply, ok := parseWho(m); ok {
+				replies = append(replies, reply)
+			}
+		case "263":
+			q.err = queryError(m)
+			return true
+		case "315":
+			return true
+		}
+		return false
+	}
+
+	if err := irc.runQuery(ctx, q, irc.whoCommand(mask)); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// Names asks server about users in channel
+func (irc *Connection) Names(ctx context.Context, channel string) ([]NamesReply, error) {
+	var replies []NamesReply
+	f := irc.Features()
+
+	q := &query{}
+	q.accept = func(m *Message) bool {
+		switch m.Command {
+		case "353":
+			return len(m.Arguments) > 2 && f.EqualFold(m.Arguments[2], channel)
+		case "366", "403":
+			return len(m.Arguments) > 1 && f.EqualFold(m.Arguments[1], channel)
+		}
+		return false
+	}
+	q.handle = func(m *Message) bool {
+		switch m.Command {
+		case "353":
+			if len(m.Arguments) < 4 {
+				return false
+			}
+			for _, name := range strings.Fields(m.Arguments[3]) {
+				i := 0
+				for i < len(name) && strings.IndexByte(f.PrefixSymbols, name[i]) > -1 {
+					i++
+				}
+				nick := name[i:]
+				if j := strings.Index(nick, "!"); j > -1 { //userhost-in-names
+					nick = nick[:j]
+				}
+				replies = append(replies, NamesReply{Nick: nick, Prefixes: name[:i]})
+			}
+		case "403":
+			q.err = queryError(m)
+			return true
+		case "366":
+			return true
+		}
+		return false
+	}
+
+	if err := irc.runQuery(ctx, q, "NAMES "+channel); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// List asks server for channel list, optional filter is passed to server (eg. ">10" or "#pony*")
+func (irc *Connection) List(ctx context.Context, filter ...string) ([]ListReply, error) {
+	var replies []ListReply
+
+	q := &query{}
+	q.accept = func(m *Message) bool {
+		switch m.Command {
+		case "321", "322", "323", "416":
+			return true
+		case "263":
+			return len(m.Arguments) > 1 && strings.EqualFold(m.Arguments[1], "LIST")
+		}
+		return false
+	}
+	q.handle = func(m *Message) bool {
+		switch m.Command {
+		case "322":
+			if len(m.Arguments) < 3 {
+				return false
+			}
+			reply := ListReply{Channel: m.Arguments[1]}
+			reply.Users, _ = strconv.Atoi(m.Arguments[2])
+			if len(m.Arguments) > 3 {
+				reply.Topic = m.Arguments[3]
+			}
+			replies = append(replies, reply)
+		case "263", "416":
+			q.err = queryError(m)
+			return true
+		case "323":
+			return true
+		}
+		return false
+	}
+
+	command := "LIST"
+	if len(filter) > 0 {
+		command += " " + strings.Join(filter, ",")
+	}
+
+	if err := irc.runQuery(ctx, q, command); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// Mode asks server for modes of channel or our nick, return's modes with parameters (eg. "+nt", "+l 10")
+func (irc *Connection) Mode(ctx context.Context, target string) (string, error) {
+	modes := ""
+	f := irc.Features()
+	channel := f.IsChannel(target)
+
+	q := &query{}
+	q.accept = func(m *Message) bool {
+		switch m.Command {
+		case "324", "403", "442", "477":
+			return channel && len(m.Arguments) > 1 && f.EqualFold(m.Arguments[1], target)
+		case "221", "502":
+			return !channel
+		}
+		return false
+	}
+	q.handle = func(m *Message) bool {
+		switch m.Command {
+		case "324":
+			modes = strings.Join(m.Arguments[2:], " ")
+		case "221":
+			modes = strings.Join(m.Arguments[1:], " ")
+		default:
+			q.err = queryError(m)
+		}
+		return true
+	}
+
+	if err := irc.runQuery(ctx, q, "MODE "+target); err != nil {
+		return "", err
+	}
+	return modes, nil
+}
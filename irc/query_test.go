@@ -0,0 +1,95 @@
+package irc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestWhois(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	go func() {
+		server.Expect(t, "WHOIS Rarity")
+		server.Send(
+			":irc.server 311 dashy Rarity rara boutique * :Rarity Belle",
+			":irc.server 319 dashy Rarity :@#pony #fashion",
+			":irc.server 330 dashy Rarity generosity :is logged in as",
+			":irc.server 318 dashy Rarity :End of /WHOIS list.",
+		)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := conn.Whois(ctx, "Rarity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Host != "boutique" || reply.RealName != "Rarity Belle" || reply.Account != "generosity" || len(reply.Channels) != 2 {
+		t.Errorf("Wrong whois reply: %#v", reply)
+	}
+}
+
+func TestWhoisNoSuchNick(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	go func() {
+		server.Expect(t, "WHOIS Discord")
+		server.Send(
+			":irc.server 401 dashy Discord :No such nick/channel",
+			":irc.server 318 dashy Discord :End of /WHOIS list.",
+		)
+	}()
+
+	_, err := conn.Whois(context.Background(), "Discord")
+	if qerr, ok := err.(*QueryError); !ok || qerr.Code != "401" {
+		t.Errorf("Expected 401 error, got: %v", err)
+	}
+}
+
+func TestLabeledWho(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	server.Send(":irc.server CAP dashy ACK :batch labeled-response")
+	irctest.Eventually(t, "labeled-response enabled", func() bool { return conn.HasCap("labeled-response") })
+
+	go func() {
+		line := server.Expect(t, "@label=")
+		label := strings.TrimPrefix(strings.Fields(line)[0], "@label=")
+		server.Send(
+			":irc.server 352 dashy #other x y irc.server Spike H :0 Spike", //someone else's reply
+			"@label="+label+" :irc.server BATCH +b1 labeled-response",
+			"@batch=b1 :irc.server 352 dashy #pony twi library irc.server Twilight H@ :0 Twilight Sparkle",
+			"@batch=b1 :irc.server 315 dashy #pony :End of /WHO list.",
+			":irc.server BATCH -b1",
+		)
+	}()
+
+	replies, err := conn.Who(context.Background(), "#pony")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].Nick != "Twilight" || replies[0].RealName != "Twilight Sparkle" {
+		t.Errorf("Wrong who reply: %#v", replies)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	conn, _ := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := conn.Names(ctx, "#pony"); err != context.DeadlineExceeded {
+		t.Errorf("Expected timeout, got: %v", err)
+	}
+}
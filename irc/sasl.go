@@ -0,0 +1,211 @@
+package irc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrSASLFailed is send to ErrorChan when SASL fails and SASLAbort is set
+var ErrSASLFailed = errors.New("SASL authentication failed!")
+
+// SASLMechanism is one SASL authentication mechanism
+type SASLMechanism interface {
+	// Name of the mechanism as used in AUTHENTICATE
+	Name() string
+	// Next return's response to server challenge (empty challenge for first step)
+	Next(challenge []byte) ([]byte, error)
+	// Reset forget's state of previous authentication, it is called before every authentication
+	Reset()
+}
+
+// NewSASLMechanism create's mechanism by name (PLAIN, EXTERNAL, SCRAM-SHA-256)
+func NewSASLMechanism(name, user, password string) (SASLMechanism, error) {
+	switch strings.ToUpper(name) {
+	case "PLAIN":
+		return &SASLPlain{User: user, Password: password}, nil
+	case "EXTERNAL":
+		return &SASLExternal{}, nil
+	case "SCRAM-SHA-256":
+		return &SASLScramSHA256{User: user, Password: password}, nil
+	}
+	return nil, errors.New("Unknown SASL mechanism \"" + name + "\"!")
+}
+
+// SASLPlain is the PLAIN mechanism (RFC 4616)
+type SASLPlain struct {
+	User     string
+	Password string
+}
+
+func (m *SASLPlain) Name() string {
+	return "PLAIN"
+}
+
+func (m *SASLPlain) Next(challenge []byte) ([]byte, error) {
+	return []byte(m.User + "\x00" + m.User + "\x00" + m.Password), nil
+}
+
+func (m *SASLPlain) Reset() {}
+
+// SASLExternal is the EXTERNAL mechanism, the identity is taken from TLS client certificate
+type SASLExternal struct{}
+
+func (m *SASLExternal) Name() string {
+	return "EXTERNAL"
+}
+
+func (m *SASLExternal) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (m *SASLExternal) Reset() {}
+
+// sasl hold's state of running authentication
+type sasl struct {
+	running bool
+	buffer  bytes.Buffer //server challenge split into more lines
+}
+
+// wantSASL adds sasl into wanted capabilities if mechanism is set
+func (irc *Connection) wantSASL() {
+	if irc.SASL != nil {
+		irc.caps.Lock()
+		irc.caps.wanted["sasl"] = true
+		irc.caps.Unlock()
+	}
+}
+
+// startSASL begins authentication after sasl capability is acknowledged
+func (irc *Connection) startSASL() {
+	if irc.SASL == nil || irc.sasl.running {
+		return
+	}
+
+	if mechs, ok := irc.CapValue("sasl"); ok && mechs != "" {
+		found := false
+		for _, mech := range strings.Split(mechs, ",") {
+			if strings.EqualFold(mech, irc.SASL.Name()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Errorf("SASL mechanism %s not supported by server (%s)", irc.SASL.Name(), mechs)
+			irc.holdRegistration()
+			irc.failSASL()
+			return
+		}
+	}
+
+	irc.sasl.running = true
+	irc.sasl.buffer.Reset()
+	irc.SASL.Reset() //mechanism may be used already by previous connection
+	irc.holdRegistration()
+
+	log.Debugf("SASL authentication using %s", irc.SASL.Name())
+	irc.SendRawf("AUTHENTICATE %s", irc.SASL.Name())
+}
+
+// handleSASL process's AUTHENTICATE and SASL numerics
+func (irc *Connection) handleSASL(event *Message) {
+	switch event.Command {
+	case "AUTHENTICATE":
+		if !irc.sasl.running || len(event.Arguments) == 0 {
+			return
+		}
+
+		data := event.Arguments[0]
+		if data != "+" {
+			irc.sasl.buffer.WriteString(data)
+			if len(data) == 400 { //more is coming
+				return
+			}
+		}
+
+		challenge, err := base64.StdEncoding.DecodeString(irc.sasl.buffer.String())
+		irc.sasl.buffer.Reset()
+		if err != nil {
+			log.Errorf("SASL bad challenge: %s", err)
+			irc.SendRaw("AUTHENTICATE *")
+			return
+		}
+
+		response, err := irc.SASL.Next(challenge)
+		if err != nil {
+			log.Errorf("SASL %s failed: %s", irc.SASL.Name(), err)
+			irc.SendRaw("AUTHENTICATE *")
+			return
+		}
+
+		irc.sendAuthenticate(response)
+
+	case "900": //RPL_LOGGEDIN
+		if len(event.Arguments) > 2 {
+			log.Infof("Logged in as %s", event.Arguments[2])
+		}
+
+	case "903": //RPL_SASLSUCCESS
+		if irc.sasl.running {
+			log.Info("SASL authentication successful.")
+			irc.sasl.running = false
+			irc.releaseRegistration()
+		}
+
+	case "904", "905", "906": //ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED
+		if irc.sasl.running {
+			log.Errorf("SASL authentication failed: %s", event.Arguments[len(event.Arguments)-1])
+			irc.sasl.running = false
+			irc.failSASL()
+		}
+
+	case "907": //ERR_SASLALREADY
+		if irc.sasl.running {
+			irc.sasl.running = false
+			irc.releaseRegistration()
+		}
+
+	case "908": //RPL_SASLMECHS
+		if len(event.Arguments) > 1 {
+			log.Infof("Server supports SASL mechanisms: %s", event.Arguments[1])
+		}
+	}
+}
+
+// failSASL abort's connection or continues unauthenticated
+func (irc *Connection) failSASL() {
+	if irc.SASLAbort {
+		irc.QuitWithMessage("SASL authentication failed")
+		select { //never block the read loop
+		case irc.ErrorChan <- ErrSASLFailed:
+		default:
+		}
+		return
+	}
+
+	log.Warn("Continuing without SASL authentication.")
+	irc.releaseRegistration()
+}
+
+// sendAuthenticate send's base64 encoded response split into 400 bytes lines
+func (irc *Connection) sendAuthenticate(response []byte) {
+	encoded := base64.StdEncoding.EncodeToString(response)
+	if encoded == "" {
+		irc.SendRaw("AUTHENTICATE +")
+		return
+	}
+
+	for len(encoded) >= 400 {
+		irc.SendRawf("AUTHENTICATE %s", encoded[:400])
+		encoded = encoded[400:]
+	}
+
+	if encoded == "" {
+		irc.SendRaw("AUTHENTICATE +")
+	} else {
+		irc.SendRawf("AUTHENTICATE %s", encoded)
+	}
+}
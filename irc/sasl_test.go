@@ -0,0 +1,143 @@
+package irc_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestSASLPlain(t *testing.T) {
+	mech, err := NewSASLMechanism("plain", "dash", "20percent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, _ := mech.Next(nil)
+	if string(response) != "dash\x00dash\x0020percent" {
+		t.Errorf("Wrong PLAIN response: %q", response)
+	}
+}
+
+// test vectors from RFC 7677
+func TestSASLScramSHA256(t *testing.T) {
+	mech := &SASLScramSHA256{User: "user", Password: "pencil", Nonce: "rOprNGfwEbeRWgbNEkqO"}
+
+	response, err := mech.Next(nil)
+	if err != nil || string(response) != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("Wrong client first message: %q %v", response, err)
+	}
+
+	response, err = mech.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	if err != nil || string(response) != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Fatalf("Wrong client final message: %q %v", response, err)
+	}
+
+	if _, err = mech.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err != nil {
+		t.Fatalf("Server signature not accepted: %v", err)
+	}
+}
+
+func TestSASLScramBadServer(t *testing.T) {
+	mech := &SASLScramSHA256{User: "user", Password: "pencil", Nonce: "rOprNGfwEbeRWgbNEkqO"}
+	mech.Next(nil)
+
+	if _, err := mech.Next([]byte("r=somebodyelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")); err == nil {
+		t.Error("Foreign nonce accepted!")
+	}
+}
+
+func TestSASLScramReset(t *testing.T) {
+	mech := &SASLScramSHA256{User: "user", Password: "pencil"}
+
+	first, _ := mech.Next(nil)
+	mech.Reset()
+	second, err := mech.Next(nil)
+	if err != nil || !strings.HasPrefix(string(second), "n,,n=user,r=") {
+		t.Fatalf("Wrong client first message after reset: %q %v", second, err)
+	}
+	if string(first) == string(second) {
+		t.Error("Nonce reused by next authentication!")
+	}
+}
+
+// expectAuthenticate wait's for AUTHENTICATE with base64 encoded response
+func expectAuthenticate(t *testing.T, server *irctest.Server, response string) {
+	line := server.Expect(t, "AUTHENTICATE ")
+	if want := "AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(response)); line != want {
+		t.Fatalf("Wrong response %q, expected %q", line, want)
+	}
+}
+
+// startSASL negotiates sasl capability until bot sends the first response
+func startSASL(t *testing.T, server *irctest.Server, mechanism string) {
+	server.Expect(t, "CAP LS 302")
+	server.Send(":irc.test CAP * LS :sasl=PLAIN,SCRAM-SHA-256 multi-prefix")
+	server.Expect(t, "CAP REQ")
+	server.Send(":irc.test CAP * ACK :sasl multi-prefix")
+	server.Expect(t, "AUTHENTICATE "+mechanism)
+	server.Send("AUTHENTICATE +")
+}
+
+func TestSASLExchange(t *testing.T) {
+	conn, server := irctest.Register(t, func(conn *Connection) {
+		conn.SASL = &SASLScramSHA256{User: "user", Password: "pencil", Nonce: "rOprNGfwEbeRWgbNEkqO"}
+	})
+	defer server.Close()
+	defer conn.Disconnect()
+
+	exchange := func() {
+		startSASL(t, server, "SCRAM-SHA-256")
+		expectAuthenticate(t, server, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO")
+		server.Send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")))
+		expectAuthenticate(t, server, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+		server.Send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")))
+		server.Expect(t, "AUTHENTICATE +")
+		server.Send(":irc.test 900 dashy dashy!grainbot@host user :You are now logged in as user", ":irc.test 903 dashy :SASL authentication successful")
+		server.Expect(t, "CAP END")
+	}
+
+	exchange()
+
+	//mechanism starts again on new connection
+	if err := conn.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	server.Accept(t)
+	exchange()
+}
+
+func TestSASLFailure(t *testing.T) {
+	for _, code := range []string{"904", "905"} {
+		for _, abort := range []bool{false, true} {
+			conn, server := irctest.Register(t, func(conn *Connection) {
+				conn.SASL = &SASLPlain{User: "dash", Password: "10percent"}
+				conn.SASLAbort = abort
+			})
+
+			startSASL(t, server, "PLAIN")
+			expectAuthenticate(t, server, "dash\x00dash\x0010percent")
+			server.Send(":irc.test "+code+" dashy :SASL authentication failed", ":irc.test 908 dashy PLAIN,SCRAM-SHA-256 :are available SASL mechanisms")
+
+			if abort {
+				server.Expect(t, "QUIT")
+				select {
+				case err := <-conn.ErrorChan:
+					if err != ErrSASLFailed {
+						t.Errorf("%s: wrong error reported: %v", code, err)
+					}
+				case <-time.After(time.Second):
+					t.Errorf("%s: failure not reported on ErrorChan!", code)
+				}
+			} else {
+				server.Expect(t, "CAP END")
+			}
+
+			conn.Disconnect()
+			server.Close()
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SASLScramSHA256 is the SCRAM-SHA-256 mechanism (RFC 7677)
+type SASLScramSHA256 struct {
+	User     string
+	Password string
+	Nonce    string //fixed client nonce (for tests), new one is generated for every authentication if empty
+
+	nonce           string //client nonce of running authentication
+	step            int
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func (m *SASLScramSHA256) Name() string {
+	return "SCRAM-SHA-256"
+}
+
+func (m *SASLScramSHA256) Reset() {
+	m.nonce = ""
+	m.step = 0
+	m.clientFirstBare = ""
+	m.serverSignature = nil
+}
+
+func (m *SASLScramSHA256) Next(challenge []byte) ([]byte, error) {
+	m.step++
+
+	switch m.step {
+	case 1:
+		m.nonce = m.Nonce
+		if m.nonce == "" {
+			nonce := make([]byte, 18)
+			if _, err := rand.Read(nonce); err != nil {
+				return nil, err
+			}
+			m.nonce = base64.RawStdEncoding.EncodeToString(nonce)
+		}
+		m.clientFirstBare = "n=" + scramEscaper.Replace(m.User) + ",r=" + m.nonce
+		return []byte("n,," + m.clientFirstBare), nil
+
+	case 2:
+		serverFirst := string(challenge)
+		attrs := parseScramAttributes(serverFirst)
+
+		nonce := attrs["r"]
+		if !strings.HasPrefix(nonce, m.nonce) || len(nonce) == len(m.nonce) {
+			return nil, errors.New("Server nonce does not match!")
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+		if err != nil {
+			return nil, errors.New("Bad salt! " + err.Error())
+		}
+
+		iterations, err := strconv.Atoi(attrs["i"])
+		if err != nil || iterations < 1 {
+			return nil, errors.New("Bad iteration count!")
+		}
+
+		saltedPassword := pbkdf2.Key([]byte(m.Password), salt, iterations, sha256.Size, sha256.New)
+		clientKey := scramHmac(saltedPassword, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+
+		clientFinal := "c=biws,r=" + nonce
+		authMessage := m.clientFirstBare + "," + serverFirst + "," + clientFinal
+
+		clientSignature := scramHmac(storedKey[:], authMessage)
+		proof := make([]byte, len(clientKey))
+		for i := range clientKey {
+			proof[i] = clientKey[i] ^ clientSignature[i]
+		}
+
+		m.serverSignature = scramHmac(scramHmac(saltedPassword, "Server Key"), authMessage)
+
+		return []byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+
+	case 3:
+		attrs := parseScramAttributes(string(challenge))
+		if e, ok := attrs["e"]; ok {
+			return nil, errors.New("Server error: " + e)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+		if err != nil || !hmac.Equal(signature, m.serverSignature) {
+			return nil, errors.New("Server signature does not match!")
+		}
+		return []byte{}, nil
+	}
+
+	return nil, errors.New("Unexpected SCRAM step!")
+}
+
+var scramEscaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func scramHmac(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func parseScramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(message, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			attrs[part[:1]] = part[2:]
+		}
+	}
+	return attrs
+}
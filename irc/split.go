@@ -0,0 +1,233 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// irc formatting codes
+const (
+	formatBold          = '\x02'
+	formatColor         = '\x03'
+	formatMonospace     = '\x11'
+	formatReverse       = '\x16'
+	formatItalic        = '\x1d'
+	formatStrikethrough = '\x1e'
+	formatUnderline     = '\x1f'
+	formatReset         = '\x0f'
+)
+
+// formatting is the state of formatting codes at some point of text
+type formatting struct {
+	bold, italic, underline, strikethrough, monospace, reverse bool
+	fg, bg                                                     string
+}
+
+// codes return's formatting codes which restore this state
+func (f formatting) codes() string {
+	codes := ""
+	if f.bold {
+		codes += string(formatBold)
+	}
+	if f.italic {
+		codes += string(formatItalic)
+	}
+	if f.underline {
+		codes += string(formatUnderline)
+	}
+	if f.strikethrough {
+		codes += string(formatStrikethrough)
+	}
+	if f.monospace {
+		codes += string(formatMonospace)
+	}
+	if f.reverse {
+		codes += string(formatReverse)
+	}
+	if f.fg != "" {
+		codes += string(formatColor) + f.fg
+		if f.bg != "" {
+			codes += "," + f.bg
+		}
+	}
+	return codes
+}
+
+// apply updates state by codes in text
+func (f formatting) apply(text string) formatting {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case formatBold:
+			f.bold = !f.bold
+		case formatItalic:
+			f.italic = !f.italic
+		case formatUnderline:
+			f.underline = !f.underline
+		case formatStrikethrough:
+			f.strikethrough = !f.strikethrough
+		case formatMonospace:
+			f.monospace = !f.monospace
+		case formatReverse:
+			f.reverse = !f.reverse
+		case formatReset:
+			f = formatting{}
+		case formatColor:
+			fg, bg, end := parseColor(text, i)
+			if fg == "" {
+				f.fg, f.bg = "", ""
+			} else {
+				f.fg = fg
+				if bg != "" {
+					f.bg = bg
+				}
+			}
+			i = end - 1
+		}
+	}
+	return f
+}
+
+// parseColor parses color code at position i, return's colors (as two digits) and end of the code
+func parseColor(text string, i int) (string, string, int) {
+	digits := func(pos int) (string, int) {
+		end := pos
+		for end < len(text) && end-pos < 2 && text[end] >= '0' && text[end] <= '9' {
+			end++
+		}
+		value := text[pos:end]
+		if len(value) == 1 {
+			value = "0" + value
+		}
+		return value, end
+	}
+
+	fg, end := digits(i + 1)
+	if fg == "" {
+		return "", "", end
+	}
+	bg := ""
+	if end+1 < len(text) && text[end] == ',' && text[end+1] >= '0' && text[end+1] <= '9' {
+		bg, end = digits(end + 1)
+	}
+	return fg, bg, end
+}
+
+// cutPoint find's where to cut text to fit max bytes - on space, or on rune boundary
+func cutPoint(text string, max int) int {
+	if max >= len(text) {
+		return len(text)
+	}
+
+	n := max
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+
+	//do not cut inside of color code
+	for p := n - 1; p >= 0 && p >= n-6; p-- {
+		if text[p] == formatColor {
+			if _, _, end := parseColor(text, p); end > n {
+				n = p
+			}
+			break
+		}
+	}
+
+	if i := strings.LastIndex(text[:n], " "); i > n/2 {
+		return i
+	}
+
+	if n == 0 { //nothing fits, take at least one rune or whole color code
+		if text[0] == formatColor {
+			_, _, end := parseColor(text, 0)
+			return end
+		}
+		_, size := utf8.DecodeRuneInString(text)
+		return size
+	}
+
+	return n
+}
+
+// SplitMessage split's text into lines of max bytes on word and rune boundaries keeping formatting
+func SplitMessage(text string, max int) []string {
+	var lines []string
+
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		line = strings.TrimRight(line, "\r")
+		state := formatting{}
+
+		for line != "" {
+			codes := state.codes()
+			if state.fg != "" && state.bg == "" && len(line) > 1 && line[0] == ',' && line[1] >= '0' && line[1] <= '9' {
+				codes += string(formatBold) + string(formatBold) //",NN" of text is not background color
+			}
+			if len(codes)+len(line) <= max {
+				lines = append(lines, codes+line)
+				break
+			}
+
+			cut := cutPoint(line, max-len(codes))
+			if len(codes)+cut > max { //no room for formatting, text goes first
+				codes = ""
+				cut = cutPoint(line, max)
+			}
+			piece := line[:cut]
+			if cut <= max { //color code longer than max is dropped
+				lines = append(lines, codes+strings.TrimRight(piece, " "))
+			}
+
+			state = state.apply(piece)
+			line = strings.TrimLeft(line[cut:], " ")
+		}
+	}
+
+	return lines
+}
+
+// limitLines keep's max lines and replaces rest by trailer
+func limitLines(lines []string, maxLines, maxLength int) []string {
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return lines
+	}
+
+	trailer := fmt.Sprintf("%c…(%d more lines)", formatReset, len(lines)-maxLines)
+	lines = lines[:maxLines]
+
+	last := lines[maxLines-1]
+	if len(last)+1+len(trailer) > maxLength {
+		last = strings.TrimRight(last[:cutPoint(last, maxLength-1-len(trailer))], " ")
+	}
+	lines[maxLines-1] = last + " " + trailer
+
+	return lines
+}
+
+// maxMessageLength return's how many bytes of text fit into one command to target
+func (irc *Connection) maxMessageLength(command, target string) int {
+	nick := irc.CurrentNick()
+	user, host := "~"+irc.Username, strings.Repeat("x", 63) //worst case if we do not know our host yet
+	if self := irc.state.User(nick); self != nil && self.Host != "" {
+		user, host = self.User, self.Host
+	}
+
+	// :nick!user@host COMMAND target :text\r\n
+	prefix := 1 + len(nick) + 1 + len(user) + 1 + len(host) + 1
+	max := irc.Features().LineLen - 2 - prefix - len(command) - 1 - len(target) - 2
+	if max < 32 {
+		max = 32
+	}
+	return max
+}
+
+// sendSplit send's text split into as many lines as needed
+func (irc *Connection) sendSplit(command, target, before, after, message string) {
+	max := irc.maxMessageLength(command, target) - len(before) - len(after)
+	for _, line := range limitLines(SplitMessage(message, max), irc.MaxLines, max) {
+		irc.SendRawf("%s %s :%s%s%s", command, target, before, line, after)
+	}
+}
@@ -0,0 +1,91 @@
+package irc_test
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	. "github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestSplitMessageWords(t *testing.T) {
+	lines := SplitMessage("twenty percent cooler than anypony", 16)
+
+	expected := []string{"twenty percent", "cooler than", "anypony"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Wrong split: %q", lines)
+	}
+}
+
+func TestSplitMessageRunes(t *testing.T) {
+	text := strings.Repeat("ponyčŠ", 20)
+	for _, line := range SplitMessage(text, 7) {
+		if len(line) > 7 || !utf8.ValidString(line) {
+			t.Fatalf("Bad line: %q", line)
+		}
+	}
+}
+
+func TestSplitMessageFormatting(t *testing.T) {
+	lines := SplitMessage("\x02bold \x0304,12red text\x0f plain", 14)
+
+	if len(lines) < 2 {
+		t.Fatalf("Not split: %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "\x02\x0304,12") {
+		t.Errorf("Formatting not restored: %q", lines)
+	}
+	for _, line := range lines {
+		if len(line) > 14 {
+			t.Errorf("Line too long: %q", line)
+		}
+	}
+}
+
+func TestSplitMessageShortFormatting(t *testing.T) {
+	for _, max := range []int{5, 10} {
+		lines := SplitMessage("\x02\x1d\x1f\x1e\x11\x16\x0304,12twenty percent cooler", max)
+
+		for _, line := range lines {
+			if len(line) > max {
+				t.Errorf("Line too long: %q", line)
+			}
+			if strings.HasSuffix(line, "\x03") || strings.HasSuffix(line, "\x030") {
+				t.Errorf("Color code split: %q", lines)
+			}
+		}
+		if !strings.Contains(strings.Join(lines, ""), "cooler") {
+			t.Errorf("Text lost: %q", lines)
+		}
+	}
+}
+
+func TestSplitMessageColorComma(t *testing.T) {
+	lines := SplitMessage("\x0304aaaa ,5bbb", 10)
+
+	expected := []string{"\x0304aaaa", "\x0304\x02\x02,5bbb"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Comma read as background color: %q", lines)
+	}
+}
+
+func TestSplitMessageNewlines(t *testing.T) {
+	lines := SplitMessage("one\r\ntwo\n\nthree", 100)
+	if strings.Join(lines, "|") != "one|two|three" {
+		t.Errorf("Wrong newline split: %q", lines)
+	}
+}
+
+func TestMaxLines(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	conn.MaxLines = 2
+	conn.Privmsg("#pony", "one\ntwo\nthree\nfour")
+
+	server.Expect(t, "PRIVMSG #pony :one")
+	if line := server.Expect(t, "PRIVMSG #pony :two"); !strings.HasSuffix(line, "…(2 more lines)") {
+		t.Errorf("Missing trailer: %q", line)
+	}
+}
@@ -0,0 +1,660 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is the tracked state of one irc user
+type User struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+	Away     bool
+	Account  string //services account, empty if not logged in or unknown
+
+	channels map[string]bool //folded names of shared channels
+}
+
+// Channel is the tracked state of one joined channel
+type Channel struct {
+	Name      string
+	Topic     string
+	TopicBy   string
+	TopicTime time.Time
+	Modes     map[byte]string //channel modes with their parameters (without prefix and list modes)
+
+	users map[string]string //folded nick -> prefix symbols (highest first)
+	names bool              //receiving NAMES reply
+}
+
+// State tracks channels the bot is in and users in them
+type State struct {
+	sync.RWMutex
+
+	fold          func(string) string //case mapping of nicks and channels
+	prefixModes   string              //modes giving prefix, eg. "ov"
+	prefixSymbols string              //prefix symbols, eg. "@+"
+	chanModes     [4]string           //CHANMODES groups A,B,C,D
+
+	channels map[string]*Channel
+	users    map[string]*User
+	accounts map[string]cachedAccount //accounts of untracked users looked up by WHOX, by folded nick
+}
+
+// accountCacheTime is how long is looked up account of untracked user remembered,
+// server does not tell us when user we don't share channel with logs in or out
+const accountCacheTime = 5 * time.Minute
+
+type cachedAccount struct {
+	account string
+	expires time.Time
+}
+
+// NewState create's empty state with RFC 1459 defaults
+func NewState() *State {
+	s := &State{
+		fold:          FoldRFC1459,
+		prefixModes:   "ov",
+		prefixSymbols: "@+",
+		chanModes:     [4]string{"b", "k", "l", "imnpst"},
+	}
+	s.clear()
+	return s
+}
+
+// clear forget's everything (on disconnect)
+func (s *State) clear() {
+	s.channels = make(map[string]*Channel)
+	s.users = make(map[string]*User)
+	s.accounts = make(map[string]cachedAccount)
+}
+
+// setFeatures switches case mapping and modes to ones told by server
+func (s *State) setFeatures(f ServerFeatures) {
+	s.Lock()
+	defer s.Unlock()
+	s.fold = f.Fold
+	s.prefixModes = f.PrefixModes
+	s.prefixSymbols = f.PrefixSymbols
+	s.chanModes = f.ChanModes
+
+	//rekey what we already know by new case mapping
+	channels, users, accounts := s.channels, s.users, s.accounts
+	s.clear()
+	for _, channel := range channels {
+		old := channel.users
+		channel.users = make(map[string]string, len(old))
+		for key, prefixes := range old {
+			if user, ok := users[key]; ok {
+				channel.users[s.fold(user.Nick)] = prefixes
+			}
+		}
+		s.channels[s.fold(channel.Name)] = channel
+	}
+	for _, user := range users {
+		old := user.channels
+		user.channels = make(map[string]bool, len(old))
+		for key := range old {
+			if channel, ok := channels[key]; ok {
+				user.channels[s.fold(channel.Name)] = true
+			}
+		}
+		s.users[s.fold(user.Nick)] = user
+	}
+	for nick, cached := range accounts {
+		s.accounts[s.fold(nick)] = cached
+	}
+}
+
+// Reset forget's all tracked channels and users
+func (s *State) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.clear()
+}
+
+// FoldRFC1459 is the default irc case mapping ({}|~ are lowercase []\^)
+func FoldRFC1459(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + 32
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+// Channels return's names of joined channels
+func (s *State) Channels() []string {
+	s.RLock()
+	defer s.RUnlock()
+	channels := make([]string, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, channel.Name)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Channel return's copy of channel state or nil if we are not in it
+func (s *State) Channel(name string) *Channel {
+	s.RLock()
+	defer s.RUnlock()
+	channel, ok := s.channels[s.fold(name)]
+	if !ok {
+		return nil
+	}
+	copied := *channel
+	copied.Modes = make(map[byte]string, len(channel.Modes))
+	for mode, param := range channel.Modes {
+		copied.Modes[mode] = param
+	}
+	copied.users = nil
+	return &copied
+}
+
+// InChannel return's true if bot is in channel
+func (s *State) InChannel(name string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.channels[s.fold(name)]
+	return ok
+}
+
+// Users return's nicks of users in channel
+func (s *State) Users(channel string) []string {
+	s.RLock()
+	defer s.RUnlock()
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return nil
+	}
+	users := make([]string, 0, len(ch.users))
+	for nick := range ch.users {
+		if user, ok := s.users[nick]; ok {
+			users = append(users, user.Nick)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// User return's copy of user state or nil if user is not seen in any of our channels
+func (s *State) User(nick string) *User {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.users[s.fold(nick)]
+	if !ok {
+		return nil
+	}
+	copied := *user
+	copied.channels = nil
+	return &copied
+}
+
+// UserChannels return's channels shared with user
+func (s *State) UserChannels(nick string) []string {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.users[s.fold(nick)]
+	if !ok {
+		return nil
+	}
+	channels := make([]string, 0, len(user.channels))
+	for name := range user.channels {
+		if channel, ok := s.channels[name]; ok {
+			channels = append(channels, channel.Name)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// CachedAccount return's account of untracked user remembered by CacheAccount
+func (s *State) CachedAccount(nick string) (string, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	cached, ok := s.accounts[s.fold(nick)]
+	if !ok || time.Now().After(cached.expires) {
+		return "", false
+	}
+	return cached.account, true
+}
+
+// CacheAccount remember's account (empty if not logged in) of untracked user for a few minutes,
+// it is forgotten sooner on NICK, QUIT or ACCOUNT of the user
+func (s *State) CacheAccount(nick, account string) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for key, cached := range s.accounts {
+		if now.After(cached.expires) {
+			delete(s.accounts, key)
+		}
+	}
+	s.accounts[s.fold(nick)] = cachedAccount{account: account, expires: now.Add(accountCacheTime)}
+}
+
+// Prefixes return's prefix symbols of user in channel (eg. "@+")
+func (s *State) Prefixes(channel, nick string) string {
+	s.RLock()
+	defer s.RUnlock()
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return ""
+	}
+	return ch.users[s.fold(nick)]
+}
+
+// HasPrefix return's true if user in channel has prefix symbol or any higher one
+func (s *State) HasPrefix(channel, nick string, symbol byte) bool {
+	s.RLock()
+	defer s.RUnlock()
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return false
+	}
+	prefixes, ok := ch.users[s.fold(nick)]
+	if !ok {
+		return false
+	}
+	rank := strings.IndexByte(s.prefixSymbols, symbol)
+	if rank < 0 {
+		return false
+	}
+	for i := 0; i < len(prefixes); i++ {
+		if r := strings.IndexByte(s.prefixSymbols, prefixes[i]); r > -1 && r <= rank {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOp return's true if user is channel operator (or higher)
+func (s *State) IsOp(channel, nick string) bool {
+	return s.HasPrefix(channel, nick, '@')
+}
+
+// IsVoice return's true if user is voiced (or higher)
+func (s *State) IsVoice(channel, nick string) bool {
+	return s.HasPrefix(channel, nick, '+')
+}
+
+// EqualFold compares nicks or channels using server case mapping
+func (s *State) EqualFold(a, b string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.fold(a) == s.fold(b)
+}
+
+// updates, all called with lock held
+
+func (s *State) user(nick string) *User {
+	key := s.fold(nick)
+	user, ok := s.users[key]
+	if !ok {
+		user = &User{Nick: nick, channels: make(map[string]bool)}
+		s.users[key] = user
+	}
+	return user
+}
+
+func (s *State) addUser(channel *Channel, nick, prefixes string) *User {
+	user := s.user(nick)
+	key := s.fold(nick)
+	channel.users[key] = s.sortPrefixes(prefixes)
+	user.channels[s.fold(channel.Name)] = true
+	return user
+}
+
+func (s *State) removeUser(channel *Channel, nick string) {
+	key := s.fold(nick)
+	delete(channel.users, key)
+	if user, ok := s.users[key]; ok {
+		delete(user.channels, s.fold(channel.Name))
+		if len(user.channels) == 0 {
+			delete(s.users, key)
+		}
+	}
+}
+
+func (s *State) removeChannel(name string) {
+	key := s.fold(name)
+	channel, ok := s.channels[key]
+	if !ok {
+		return
+	}
+	for nick := range channel.users {
+		if user, ok := s.users[nick]; ok {
+			delete(user.channels, key)
+			if len(user.channels) == 0 {
+				delete(s.users, nick)
+			}
+		}
+	}
+	delete(s.channels, key)
+}
+
+// sortPrefixes order's prefix symbols from the highest
+func (s *State) sortPrefixes(prefixes string) string {
+	if len(prefixes) < 2 {
+		return prefixes
+	}
+	sorted := make([]byte, 0, len(prefixes))
+	for i := 0; i < len(s.prefixSymbols); i++ {
+		if strings.IndexByte(prefixes, s.prefixSymbols[i]) > -1 {
+			sorted = append(sorted, s.prefixSymbols[i])
+		}
+	}
+	return string(sorted)
+}
+
+// splitPrefixes split's "@+nick" into "@+" and "nick"
+func (s *State) splitPrefixes(name string) (string, string) {
+	i := 0
+	for i < len(name) && strings.IndexByte(s.prefixSymbols, name[i]) > -1 {
+		i++
+	}
+	return name[:i], name[i:]
+}
+
+// handleState update's state from irc message and send's queries about new channels
+func (irc *Connection) handleState(event *Message) {
+	for _, command := range irc.updateState(event) {
+		irc.SendRaw(command) //never with state locked, write can block
+	}
+}
+
+// updateState update's state and return's commands to send once it is unlocked
+func (irc *Connection) updateState(event *Message) []string {
+	var send []string
+	s := irc.state
+	s.Lock()
+	defer s.Unlock()
+
+	self := func(nick string) bool {
+		return s.fold(nick) == s.fold(irc.currentNickname)
+	}
+	arg := func(i int) string {
+		if i < len(event.Arguments) {
+			return event.Arguments[i]
+		}
+		return ""
+	}
+
+	switch event.Command {
+	case "JOIN":
+		name := arg(0)
+		if self(event.Nick) {
+			s.removeChannel(name) //stale state from before netsplit
+			s.channels[s.fold(name)] = &Channel{Name: name, Modes: make(map[byte]string), users: make(map[string]string)}
+			send = append(send, "MODE "+name, irc.whoCommand(name))
+		}
+		if channel, ok := s.channels[s.fold(name)]; ok {
+			user := s.addUser(channel, event.Nick, "")
+			user.User, user.Host = event.User, event.Host
+			if len(event.Arguments) > 2 { //extended-join: #channel account :realname
+				user.Account, user.RealName = arg(1), arg(2)
+				if user.Account == "*" {
+					user.Account = ""
+				}
+			}
+		}
+
+	case "PART":
+		name := arg(0)
+		if self(event.Nick) {
+			s.removeChannel(name)
+		} else if channel, ok := s.channels[s.fold(name)]; ok {
+			s.removeUser(channel, event.Nick)
+		}
+
+	case "KICK":
+		name, nick := arg(0), arg(1)
+		if self(nick) {
+			s.removeChannel(name)
+		} else if channel, ok := s.channels[s.fold(name)]; ok {
+			s.removeUser(channel, nick)
+		}
+
+	case "QUIT":
+		key := s.fold(event.Nick)
+		delete(s.accounts, key)
+		if user, ok := s.users[key]; ok {
+			for name := range user.channels {
+				if channel, ok := s.channels[name]; ok {
+					delete(channel.users, key)
+				}
+			}
+			delete(s.users, key)
+		}
+
+	case "NICK":
+		if self(event.Nick) { //before anything else see's the message
+			irc.currentNickname = arg(0)
+		}
+		oldKey, newKey := s.fold(event.Nick), s.fold(arg(0))
+		delete(s.accounts, oldKey)
+		delete(s.accounts, newKey)
+		user, ok := s.users[oldKey]
+		if !ok {
+			return nil
+		}
+		user.Nick = arg(0)
+		delete(s.users, oldKey)
+		s.users[newKey] = user
+		for name := range user.channels {
+			if channel, ok := s.channels[name]; ok {
+				prefixes := channel.users[oldKey]
+				delete(channel.users, oldKey)
+				channel.users[newKey] = prefixes
+			}
+		}
+
+	case "MODE":
+		if channel, ok := s.channels[s.fold(arg(0))]; ok && len(event.Arguments) > 1 {
+			s.applyModes(channel, event.Arguments[1], event.Arguments[2:])
+		}
+
+	case "324": //RPL_CHANNELMODEIS
+		if channel, ok := s.channels[s.fold(arg(1))]; ok && len(event.Arguments) > 2 {
+			channel.Modes = make(map[byte]string)
+			s.applyModes(channel, event.Arguments[2], event.Arguments[3:])
+		}
+
+	case "TOPIC":
+		if channel, ok := s.channels[s.fold(arg(0))]; ok {
+			channel.Topic = arg(1)
+			channel.TopicBy = event.Prefix
+			channel.TopicTime = event.ServerTime()
+		}
+
+	case "331": //RPL_NOTOPIC
+		if channel, ok := s.channels[s.fold(arg(1))]; ok {
+			channel.Topic = ""
+		}
+
+	case "332": //RPL_TOPIC
+		if channel, ok := s.channels[s.fold(arg(1))]; ok {
+			channel.Topic = arg(2)
+		}
+
+	case "333": //RPL_TOPICWHOTIME
+		if channel, ok := s.channels[s.fold(arg(1))]; ok {
+			channel.TopicBy = arg(2)
+			if ts, err := strconv.ParseInt(arg(3), 10, 64); err == nil {
+				channel.TopicTime = time.Unix(ts, 0)
+			}
+		}
+
+	case "353": //RPL_NAMREPLY: me = #channel :names
+		channel, ok := s.channels[s.fold(arg(2))]
+		if !ok {
+			return nil
+		}
+		if !channel.names { //new listing replaces the old one
+			channel.names = true
+			for nick := range channel.users {
+				s.removeUser(channel, nick)
+			}
+		}
+		for _, name := range strings.Fields(arg(3)) {
+			prefixes, name := s.splitPrefixes(name)
+			nick, ident, host := name, "", ""
+			if i, j := strings.Index(name, "!"), strings.Index(name, "@"); i > -1 && j > i { //userhost-in-names
+				nick, ident, host = name[:i], name[i+1:j], name[j+1:]
+			}
+			user := s.addUser(channel, nick, prefixes)
+			if host != "" {
+				user.User, user.Host = ident, host
+			}
+		}
+
+	case "366": //RPL_ENDOFNAMES
+		if channel, ok := s.channels[s.fold(arg(1))]; ok {
+			channel.names = false
+		}
+
+	case "352", "354": //RPL_WHOREPLY or WHOX reply with account
+		reply, ok := parseWho(event)
+		if !ok {
+			return nil
+		}
+		user, ok := s.users[s.fold(reply.Nick)]
+		if !ok {
+			return nil
+		}
+		user.User, user.Host = reply.User, reply.Host
+		user.Away = reply.Away()
+		if reply.RealName != "" {
+			user.RealName = reply.RealName
+		}
+		if event.Command == "354" {
+			user.Account = reply.Account
+		}
+		if channel, ok := s.channels[s.fold(reply.Channel)]; ok {
+			if _, in := channel.users[s.fold(reply.Nick)]; in {
+				prefixes := ""
+				for i := 0; i < len(reply.Flags); i++ {
+					if strings.IndexByte(s.prefixSymbols, reply.Flags[i]) > -1 {
+						prefixes += string(reply.Flags[i])
+					}
+				}
+				channel.users[s.fold(reply.Nick)] = s.sortPrefixes(prefixes)
+			}
+		}
+
+	case "319": //RPL_WHOISCHANNELS, used to find our channels after restart
+		if !self(arg(1)) {
+			return nil
+		}
+		for _, name := range strings.Fields(arg(2)) {
+			_, name = s.splitPrefixes(name)
+			if _, ok := s.channels[s.fold(name)]; !ok {
+				s.channels[s.fold(name)] = &Channel{Name: name, Modes: make(map[byte]string), users: make(map[string]string)}
+				send = append(send, "NAMES "+name, "MODE "+name, irc.whoCommand(name))
+			}
+		}
+
+	case "AWAY": //away-notify
+		if user, ok := s.users[s.fold(event.Nick)]; ok {
+			user.Away = len(event.Arguments) > 0
+		}
+
+	case "CHGHOST":
+		if user, ok := s.users[s.fold(event.Nick)]; ok {
+			user.User, user.Host = arg(0), arg(1)
+		}
+
+	case "ACCOUNT": //account-notify, * is logout
+		delete(s.accounts, s.fold(event.Nick))
+		if user, ok := s.users[s.fold(event.Nick)]; ok {
+			user.Account = arg(0)
+			if user.Account == "*" {
+				user.Account = ""
+			}
+		}
+	}
+
+	return send
+}
+
+// handleAccountTag update's account of known user from account-tag
+func (irc *Connection) handleAccountTag(event *Message) {
+	s := irc.state
+	s.Lock()
+	defer s.Unlock()
+	if user, ok := s.users[s.fold(event.Nick)]; ok {
+		user.Account = event.Tags["account"]
+	}
+}
+
+// applyModes applies mode string with parameters onto channel
+func (s *State) applyModes(channel *Channel, modes string, params []string) {
+	adding := true
+	next := func() string {
+		if len(params) == 0 {
+			return ""
+		}
+		param := params[0]
+		params = params[1:]
+		return param
+	}
+
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		switch {
+		case mode == '+':
+			adding = true
+		case mode == '-':
+			adding = false
+		case strings.IndexByte(s.prefixModes, mode) > -1:
+			nick := next()
+			key := s.fold(nick)
+			prefixes, ok := channel.users[key]
+			if !ok {
+				continue
+			}
+			symbol := s.prefixSymbols[strings.IndexByte(s.prefixModes, mode)]
+			prefixes = strings.Replace(prefixes, string(symbol), "", -1)
+			if adding {
+				prefixes += string(symbol)
+			}
+			channel.users[key] = s.sortPrefixes(prefixes)
+		case strings.IndexByte(s.chanModes[0], mode) > -1: //list modes (bans etc.) are not tracked
+			next()
+		case strings.IndexByte(s.chanModes[1], mode) > -1: //always parameter
+			param := next()
+			if adding {
+				channel.Modes[mode] = param
+			} else {
+				delete(channel.Modes, mode)
+			}
+		case strings.IndexByte(s.chanModes[2], mode) > -1: //parameter only when set
+			if adding {
+				channel.Modes[mode] = next()
+			} else {
+				delete(channel.Modes, mode)
+			}
+		default:
+			if adding {
+				channel.Modes[mode] = ""
+			} else {
+				delete(channel.Modes, mode)
+			}
+		}
+	}
+}
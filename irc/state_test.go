@@ -0,0 +1,117 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/natrim/grainbot/irc/irctest"
+)
+
+func TestStateTracking(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+	state := conn.State()
+
+	server.Send(
+		":dashy!grainbot@bot.host JOIN #Pony",
+		":irc.server 353 dashy = #pony :dashy @Twilight +Rarity Spike",
+		":irc.server 366 dashy #pony :End of /NAMES list.",
+		":irc.server 332 dashy #pony :Friendship is magic",
+	)
+
+	irctest.Eventually(t, "channel joined", func() bool {
+		channel := state.Channel("#PONY")
+		return channel != nil && channel.Topic != "" && len(state.Users("#PONY")) == 4
+	})
+
+	if !state.IsOp("#pony", "twilight") || state.IsOp("#pony", "Rarity") || !state.IsVoice("#pony", "Rarity") {
+		t.Error("Wrong prefixes after NAMES")
+	}
+	if state.Channel("#pony").Topic != "Friendship is magic" || state.Channel("#pony").Name != "#Pony" {
+		t.Error("Topic not tracked")
+	}
+
+	server.Send(
+		":Twilight!twi@library MODE #pony -o+v Twilight Twilight",
+		":Spike!spike@library NICK Spike[dragon]",
+		":Rarity!rara@boutique QUIT :*.net *.split",
+		":irc.server 352 dashy #pony twi library irc.server Twilight H+ :0 Twilight Sparkle",
+	)
+
+	irctest.Eventually(t, "who reply", func() bool { u := state.User("twilight"); return u != nil && u.Host == "library" })
+
+	if state.IsOp("#pony", "Twilight") || !state.IsVoice("#pony", "Twilight") {
+		t.Error("Mode change not tracked")
+	}
+	if state.User("rarity") != nil {
+		t.Error("Quit not tracked")
+	}
+	if state.User("spike{DRAGON}") == nil || state.User("spike") != nil {
+		t.Error("Nick change not tracked")
+	}
+
+	server.Send(":Twilight!twi@library KICK #pony dashy :out!")
+	irctest.Eventually(t, "kicked", func() bool { return len(state.Channels()) == 0 && state.User("Twilight") == nil })
+}
+
+func TestOwnNickTracking(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+	state := conn.State()
+
+	//server renames us and we join right away
+	server.Send(
+		":dashy!grainbot@bot.host NICK Rainbow",
+		":Rainbow!grainbot@bot.host JOIN #cloudsdale",
+	)
+	irctest.Eventually(t, "channel joined under new nick", func() bool {
+		return state.Channel("#cloudsdale") != nil
+	})
+	if conn.CurrentNick() != "Rainbow" {
+		t.Error("Nick change not tracked: ", conn.CurrentNick())
+	}
+
+	conn.Nick("Spike")
+	server.Expect(t, "NICK Spike")
+	server.Send(":irc.server 433 Rainbow Spike :Nickname is already in use")
+	server.Expect(t, "NICK Spike_")
+	if conn.CurrentNick() != "Spike_" {
+		t.Error("Nick in use not handled: ", conn.CurrentNick())
+	}
+}
+
+func TestAccountTracking(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+	state := conn.State()
+
+	server.Send(
+		":irc.server 005 dashy WHOX :are supported by this server",
+		":dashy!grainbot@bot.host JOIN #pony * :Botus Grainus",
+	)
+	server.Expect(t, "WHO #pony %tcuhsnfdar,616")
+
+	server.Send(
+		":irc.server 353 dashy = #pony :dashy Twilight Rarity",
+		":irc.server 366 dashy #pony :End of /NAMES list.",
+		":irc.server 354 dashy 616 #pony twi library irc.server Twilight H 0 twilight :Twilight Sparkle",
+		":irc.server 354 dashy 616 #pony rara boutique irc.server Rarity H 0 0 :Rarity",
+		":Spike!spike@library JOIN #pony spike :Spike the dragon",
+	)
+
+	irctest.Eventually(t, "accounts", func() bool {
+		u := state.User("spike")
+		return u != nil && u.Account == "spike" && state.User("twilight").Account == "twilight"
+	})
+	if state.User("rarity").Account != "" {
+		t.Error("Not logged in user has account")
+	}
+
+	server.Send(
+		":Rarity!rara@boutique ACCOUNT rarity",
+		":Twilight!twi@library ACCOUNT *",
+		"@account=spikey :Spike!spike@library PRIVMSG #pony :gems?",
+	)
+	irctest.Eventually(t, "account changes", func() bool {
+		return state.User("rarity").Account == "rarity" && state.User("twilight").Account == "" && state.User("spike").Account == "spikey"
+	})
+}
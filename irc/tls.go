@@ -0,0 +1,110 @@
+package irc
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TLSOptions configure secured connection
+type TLSOptions struct {
+	CertFile    string //client certificate for CertFP or SASL EXTERNAL
+	KeyFile     string //key of client certificate
+	CAFile      string //CA bundle to verify server against instead of system roots
+	Fingerprint string //SHA-256 fingerprint of server certificate to pin (hex, colons allowed)
+	ServerName  string //override server name used for verification and SNI
+	Insecure    bool   //do not verify server certificate (for testing only!)
+	MinVersion  string //minimal TLS version: 1.0, 1.1, 1.2 or 1.3
+}
+
+// FingerprintError is returned when server certificate does not match pinned fingerprint
+type FingerprintError struct {
+	Expected string
+	Got      string
+}
+
+func (e *FingerprintError) Error() string {
+	return "Server certificate fingerprint mismatch! Expected " + e.Expected + ", got " + e.Got
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// normalizeFingerprint lowercases fingerprint and strips colons and spaces
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fp))
+}
+
+// tlsConfig build's tls.Config from connection TLS options
+func (irc *Connection) tlsConfig() (*tls.Config, error) {
+	options := irc.TLS
+
+	config := &tls.Config{ServerName: irc.Hostname}
+	if options.ServerName != "" {
+		config.ServerName = options.ServerName
+	}
+
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, errors.New("Unknown TLS version \"" + options.MinVersion + "\"!")
+		}
+		config.MinVersion = version
+	}
+
+	if options.CertFile != "" {
+		keyFile := options.KeyFile
+		if keyFile == "" { //key bundled in cert file
+			keyFile = options.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(options.CertFile, keyFile)
+		if err != nil {
+			return nil, errors.New("Cannot load client certificate! " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, errors.New("Cannot load CA bundle! " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in CA bundle!")
+		}
+		config.RootCAs = pool
+	}
+
+	if options.Fingerprint != "" {
+		//pinned certificate replaces the chain verification so self-signed networks work
+		expected := normalizeFingerprint(options.Fingerprint)
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return &FingerprintError{Expected: expected, Got: "nothing"}
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			got := hex.EncodeToString(sum[:])
+			if got != expected {
+				return &FingerprintError{Expected: expected, Got: got}
+			}
+			return nil
+		}
+	} else if options.Insecure {
+		log.Warn("TLS certificate verification is disabled!")
+		config.InsecureSkipVerify = true
+	}
+
+	return config, nil
+}
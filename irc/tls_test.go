@@ -0,0 +1,57 @@
+package irc_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/natrim/grainbot/irc"
+)
+
+func pinnedConnection(t *testing.T, fingerprint string) (*Connection, *httptest.Server) {
+	server := httptest.NewTLSServer(nil)
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	conn := NewConnection("dashy", "grainbot", "Botus Grainus")
+	conn.Hostname = host
+	conn.Port, _ = strconv.Atoi(port)
+	conn.Secured = true
+	conn.TLS.Fingerprint = fingerprint
+
+	return conn, server
+}
+
+func TestFingerprintPin(t *testing.T) {
+	conn, server := pinnedConnection(t, "")
+	defer server.Close()
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	conn.TLS.Fingerprint = hex.EncodeToString(sum[:])
+
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Pinned connection failed: %v", err)
+	}
+	conn.Disconnect()
+}
+
+func TestFingerprintMismatch(t *testing.T) {
+	conn, server := pinnedConnection(t, "AA:BB:CC")
+	defer server.Close()
+
+	err := conn.Connect()
+	if _, ok := err.(*FingerprintError); !ok {
+		t.Fatalf("Expected fingerprint error, got: %v", err)
+	}
+
+	select {
+	case e := <-conn.ErrorChan:
+		if _, ok := e.(*FingerprintError); !ok {
+			t.Errorf("Wrong error reported: %v", e)
+		}
+	default:
+		t.Error("Mismatch not reported on ErrorChan!")
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"flag"
+
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/natrim/grainbot/modules"
 	"github.com/natrim/grainbot/modules/autojoin"
 	"github.com/natrim/grainbot/modules/fun"
@@ -10,6 +14,13 @@ import (
 var grainbot *Bot
 
 func main() {
+	flag.Parse()
+
+	log.SetFormatter(&PrettyFormatter{})
+	if *debug == true {
+		log.SetLevel(log.DebugLevel)
+	}
+
 	grainbot = NewBot()
 
 	//register modules
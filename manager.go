@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/modules"
+)
+
+// Modules return's names of registered modules
+func (b *Bot) Modules() []string {
+	names := make([]string, 0, len(b.modules))
+	for name := range b.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ModuleActive return's true if module is loaded
+func (b *Bot) ModuleActive(name string) bool {
+	mod := b.modules[strings.ToLower(name)]
+	return mod != nil && mod.IsActive()
+}
+
+// EnableModule activate's module and remove's it from disabled modules in config
+func (b *Bot) EnableModule(name string) error {
+	b.mlock.Lock()
+	defer b.mlock.Unlock()
+
+	mod, err := b.module(name)
+	if err != nil {
+		return err
+	}
+
+	if err := b.checkDependencies(mod); err != nil {
+		return err
+	}
+
+	return b.toggleModule(mod, false)
+}
+
+// DisableModule deactivate's module and add's it to disabled modules in config
+func (b *Bot) DisableModule(name string) error {
+	b.mlock.Lock()
+	defer b.mlock.Unlock()
+
+	mod, err := b.module(name)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range b.order {
+		if other.IsActive() && other != mod && dependsOn(other, mod) {
+			return errors.New("Module \"" + other.Name() + "\" needs \"" + mod.Name() + "\"!")
+		}
+	}
+
+	return b.toggleModule(mod, true)
+}
+
+// toggleModule disable's or enable's module and save's config, nothing is changed if config can not be saved
+func (b *Bot) toggleModule(mod *modules.Module, disable bool) error {
+	if b.Config.Modified() {
+		return config.ErrConfigModified
+	}
+
+	wasDisabled, wasActive := moduleDisabled(b.Config, mod.Name()), mod.IsActive()
+	b.setDisabled(mod.Name(), disable)
+	if disable {
+		b.deactivateModule(mod)
+	} else {
+		b.activateModule(mod)
+	}
+
+	if err := b.Config.Save(); err != nil {
+		b.setDisabled(mod.Name(), wasDisabled)
+		if wasActive {
+			b.activateModule(mod)
+		} else {
+			b.deactivateModule(mod)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReloadModule deactivate's and activate's module again, so it reads config again
+func (b *Bot) ReloadModule(name string) error {
+	b.mlock.Lock()
+	defer b.mlock.Unlock()
+
+	mod, err := b.module(name)
+	if err != nil {
+		return err
+	}
+	if !mod.IsActive() {
+		return errors.New("Module \"" + name + "\" is disabled!")
+	}
+
+	b.deactivateModule(mod)
+	b.activateModule(mod)
+	return nil
+}
+
+// checkDependencies return's error if some dependency of module is not active
+func (b *Bot) checkDependencies(mod *modules.Module) error {
+	for _, dep := range mod.Depends {
+		if !b.ModuleActive(dep) {
+			return errors.New("Module \"" + mod.Name() + "\" needs disabled module \"" + dep + "\"!")
+		}
+	}
+	return nil
+}
+
+func dependsOn(mod, dep *modules.Module) bool {
+	for _, name := range mod.Depends {
+		if strings.EqualFold(name, dep.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) module(name string) (*modules.Module, error) {
+	mod := b.modules[strings.ToLower(name)]
+	if mod == nil {
+		return nil, errors.New("No module \"" + name + "\"!")
+	}
+	return mod, nil
+}
+
+// activateModule load's module if not loaded
+func (b *Bot) activateModule(mod *modules.Module) {
+	if mod.IsActive() {
+		return
+	}
+	b.mwg.Add(1)
+	mod.Activate()
+	log.Debug("Module \"" + mod.Name() + "\" loaded.")
+}
+
+// deactivateModule unload's module if loaded
+func (b *Bot) deactivateModule(mod *modules.Module) {
+	if !mod.IsActive() {
+		return
+	}
+	mod.Deactivate()
+	b.mwg.Done()
+	log.Debug("Module \"" + mod.Name() + "\" unloaded.")
+}
+
+// moduleDisabled return's true if module is in disabled modules of config
+func moduleDisabled(conf *config.Configuration, name string) bool {
+	conf.RLock()
+	defer conf.RUnlock()
+	for _, disabled := range conf.DisabledModules {
+		if strings.EqualFold(disabled, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) setDisabled(name string, disabled bool) {
+	if moduleDisabled(b.Config, name) == disabled {
+		return
+	}
+
+	b.Config.Lock()
+	defer b.Config.Unlock()
+	if disabled {
+		b.Config.DisabledModules = append(b.Config.DisabledModules, strings.ToLower(name))
+		return
+	}
+	list := config.StringList{}
+	for _, d := range b.Config.DisabledModules {
+		if !strings.EqualFold(d, name) {
+			list = append(list, d)
+		}
+	}
+	b.Config.DisabledModules = list
+}
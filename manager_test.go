@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc/irctest"
+	"github.com/natrim/grainbot/modules"
+	"github.com/natrim/grainbot/modules/system"
+)
+
+// startBot return's bot with config file in temp dir connected to fake server, stop cleans up everything
+func startBot(t *testing.T, content string, mods ...*modules.Module) (*Bot, *irctest.Server, func()) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBot()
+	b.Connection = irctest.NewConnection()
+	if err := b.Config.LoadFromFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, mod := range mods {
+		b.RegisterModule(mod)
+	}
+	if b.order, err = modules.SortModules(mods); err != nil {
+		t.Fatal(err)
+	}
+
+	modules.SetManager(b)
+	modules.Start(b.Connection, b.Config)
+	b.applyDisabledModules()
+	server := irctest.Connect(t, b.Connection)
+
+	return b, server, func() {
+		b.Connection.Disconnect()
+		for i := len(b.order) - 1; i >= 0; i-- {
+			b.deactivateModule(b.order[i])
+		}
+		modules.Stop(b.Connection, b.Config)
+		modules.CloseStore()
+		modules.SetManager(nil)
+		os.RemoveAll(dir)
+	}
+}
+
+// touchConfig change's config file behind bot's back
+func touchConfig(t *testing.T, b *Bot) {
+	file := filepath.Join(b.Config.Dir(), "config.json")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManageModules(t *testing.T) {
+	inits := 0
+	pony := modules.NewModule("pony", func(*modules.Module) { inits++ }, nil)
+	derpy := modules.NewModule("derpy", nil, func(m *modules.Module) {
+		m.IsActive() //halt must not deadlock
+	}).DependsOn("pony")
+
+	b, _, stop := startBot(t, `{"HostName": "irc.test"}`, pony, derpy)
+	defer stop()
+
+	if !b.ModuleActive("pony") || !b.ModuleActive("derpy") || inits != 1 {
+		t.Fatal("Modules not activated")
+	}
+	if err := b.DisableModule("pony"); err == nil || !b.ModuleActive("pony") {
+		t.Error("Module needed by other disabled")
+	}
+
+	if err := b.DisableModule("derpy"); err != nil {
+		t.Fatal(err)
+	}
+	if b.ModuleActive("derpy") || !moduleDisabled(b.Config, "derpy") {
+		t.Error("Module not disabled")
+	}
+	saved, _ := config.LoadConfigFromFile(filepath.Join(b.Config.Dir(), "config.json"))
+	if len(saved.DisabledModules) != 1 || saved.DisabledModules[0] != "derpy" {
+		t.Error("Disabled module not saved: ", saved.DisabledModules)
+	}
+
+	//nothing changes when config can not be saved
+	touchConfig(t, b)
+	if err := b.EnableModule("derpy"); err != config.ErrConfigModified {
+		t.Error("Config conflict not reported: ", err)
+	}
+	if b.ModuleActive("derpy") || !moduleDisabled(b.Config, "derpy") {
+		t.Error("Module enabled without saving config")
+	}
+
+	if _, err := b.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnableModule("derpy"); err != nil || !b.ModuleActive("derpy") || moduleDisabled(b.Config, "derpy") {
+		t.Error("Module not enabled: ", err)
+	}
+
+	if err := b.ReloadModule("pony"); err != nil || inits != 2 {
+		t.Error("Module not reloaded: ", err)
+	}
+	if err := b.ReloadModule("unicorn"); err == nil {
+		t.Error("Unknown module reloaded")
+	}
+}
+
+func TestModuleCommand(t *testing.T) {
+	sys := modules.NewModule("system", system.InitSystem, nil)
+	pony := modules.NewModule("pony", nil, nil)
+
+	b, server, stop := startBot(t, `{"HostName": "irc.test", "Owner": ["Twilight!*@*"]}`, sys, pony)
+	defer stop()
+
+	expect := func(message, reply string) {
+		server.Send(":Twilight!twi@library PRIVMSG #pony :dashy: " + message)
+		if line, ok := server.Wait(time.Second, "PRIVMSG"); !ok || line != "PRIVMSG #pony :"+reply {
+			t.Errorf("%q got reply %q, expected %q", message, line, reply)
+		}
+	}
+
+	expect("module disable pony #pony", "okey, Twilight! pony is disabled in #pony")
+	expect("module list", "modules: pony (disabled here), system")
+	server.Send(":Twilight!twi@library NOTICE dashy :module list")
+	server.Send(":Twilight!twi@library NOTICE #pony :dashy: module list")
+	expect("module list", "modules: pony (disabled here), system")
+	if line, ok := server.Wait(100*time.Millisecond, "PRIVMSG", "NOTICE"); ok {
+		t.Errorf("Notice answered: %q", line)
+	}
+
+	touchConfig(t, b)
+	expect("module enable pony #pony", "Twilight, "+config.ErrConfigModified.Error())
+	if !modules.DisabledIn(b.Config, b.Connection.Features(), "pony", "#pony") {
+		t.Error("Module enabled in channel without saving config")
+	}
+	expect("module disable pony", "Twilight, "+config.ErrConfigModified.Error())
+	if !b.ModuleActive("pony") {
+		t.Error("Module disabled without saving config")
+	}
+
+	b.ReloadConfig()
+	expect("module enable pony #pony", "okey, Twilight! pony is enabled in #pony")
+	expect("module disable pony", "okey, Twilight! pony is disabled")
+	expect("module list", "modules: pony (disabled), system")
+	expect("module disable system", "Twilight, i can't disable myself!")
+
+	expect("acl add Spike op #pony", "okey, Twilight! Spike is op in #pony")
+	expect("acl list #pony", "Spike!*@* op in #pony")
+	expect("ignore add Derpy", "okey, Twilight! ignoring Derpy everywhere")
+	expect("ignore del Derpy #pony", "Twilight, No such mask in ignore list!")
+	expect("ignore del Derpy", "okey, Twilight! listening to Derpy everywhere again")
+	expect("ignore list", "i listen to everypony!")
+	if b.ModuleActive("pony") || !strings.Contains(b.Config.String(), "pony") {
+		t.Error("Module not disabled")
+	}
+
+	expect("nick Spike", "okey, Twilight! Let'z talk as another pony!")
+	if b.Connection.Nickname != "Spike" || b.Connection.GetNick() != "Spike" {
+		t.Error("Nick not changed: ", b.Connection.Nickname, b.Connection.GetNick())
+	}
+}
+
+func TestBeforeRestartHook(t *testing.T) {
+	pony := modules.NewModule("pony", nil, nil)
+	pony.OnBeforeRestart = func(m *modules.Module) {
+		m.GetConnection().Privmsg("#pony", "brb, restarting")
+		if err := m.Store().Put("restarted", []byte("yes")); err != nil {
+			t.Error("Store closed before hook: ", err)
+		}
+	}
+
+	b, server, stop := startBot(t, `{"HostName": "irc.test"}`, pony)
+	defer stop()
+
+	if err := b.beforeFork(); err != nil {
+		t.Fatal(err)
+	}
+	server.Expect(t, "PRIVMSG #pony :brb, restarting")
+}
@@ -1,14 +1,21 @@
 package modules
 
+import (
+	"sync"
+
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/permissions"
+)
+
 // OwnerPermission - only mine permission
 type OwnerPermission struct{}
 
-var ownerNick = ""
+var owner = permissions.NewHostmaskPermission()
+var ownerLock sync.RWMutex //masks are replaced on config reload
 
 // Validate validate's me
-func (p *OwnerPermission) Validate(nick, user, host string) bool {
-	if nick != ownerNick {
-		return false
-	}
-	return true
+func (p *OwnerPermission) Validate(m *irc.Message) bool {
+	ownerLock.RLock()
+	defer ownerLock.RUnlock()
+	return owner.Validate(m)
 }
@@ -1,17 +1,69 @@
 package autojoin
 
 import (
+	"sync"
+
+	"github.com/natrim/grainbot/config"
 	"github.com/natrim/grainbot/irc"
 	"github.com/natrim/grainbot/modules"
 )
 
+// channels joined by config
+var joined []string
+var lock sync.Mutex
+
 func Init(mod *modules.Module) {
+	lock.Lock()
+	joined = mod.GetConfig().GetStringSlice("autojoin.channels") //after restart we are in them already
+	lock.Unlock()
+
 	mod.AddIrcMessageHandler("join on ok", func(event *irc.Message) {
 		if event.Command == "001" {
 			channels := mod.GetConfig().GetStringSlice("autojoin.channels")
 			for _, chn := range channels {
 				event.Server.Join(chn)
 			}
+
+			lock.Lock()
+			joined = channels
+			lock.Unlock()
 		}
 	}, nil)
+
+	mod.OnConfigChange = func(mod *modules.Module, changes config.Changes) {
+		if !changes.Has("autojoin.channels") {
+			return
+		}
+		channels := mod.GetConfig().GetStringSlice("autojoin.channels")
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		conn := mod.GetConnection()
+		if conn.IsConnected {
+			features := conn.Features()
+			for _, chn := range diff(features, channels, joined) {
+				conn.Join(chn)
+			}
+			for _, chn := range diff(features, joined, channels) {
+				conn.Part(chn)
+			}
+		}
+		joined = channels
+	}
+}
+
+// diff return's channels of a missing in b
+func diff(features irc.ServerFeatures, a, b []string) []string {
+	var missing []string
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			found = found || features.EqualFold(x, y)
+		}
+		if !found {
+			missing = append(missing, x)
+		}
+	}
+	return missing
 }
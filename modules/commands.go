@@ -2,55 +2,604 @@ package modules
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natrim/grainbot/config"
 	"github.com/natrim/grainbot/irc"
 	"github.com/natrim/grainbot/permissions"
-	"strings"
 )
 
+// COMMAND_DELIMITER is default command prefix, used when config has none
 const COMMAND_DELIMITER = "."
 
+// ArgType is type of command argument or flag value
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgFloat
+	ArgBool
+	ArgDuration //eg. 5m, 1h30m
+	ArgNick
+	ArgChannel
+	ArgText //rest of the line, only as last argument
+)
+
+var argTypeNames = []string{"text", "number", "number", "yes/no", "duration", "nick", "channel", "text"}
+
+// Arg is positional argument of command
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	Default  string //used when optional argument is missing
+}
+
+// Flag is -name (for ArgBool) or -name=value option of command
+type Flag struct {
+	Name    string
+	Type    ArgType
+	Usage   string
+	Default string
+}
+
+// CommandSpec describes command, its arguments and who can use it
+type CommandSpec struct {
+	Name        string
+	Aliases     []string
+	Usage       string //generated from arguments if empty
+	Description string
+	Args        []Arg
+	Flags       []Flag
+	Role        permissions.Role       //minimum role, at least user
+	Permission  permissions.Permission //checked together with role, optional
+	Limits      Limits                 //cooldowns and rate limits, owner is not limited
+
+	module string
+}
+
+// Command is parsed invocation of command
 type Command struct {
 	*irc.Message
-	Text string
+	Text   string //whole message text
+	Prefix string //prefix used, eg. "." or "dashy: "
+	Name   string //name or alias used
+	Spec   *CommandSpec
+	Args   map[string]interface{}
+	Flags  map[string]interface{}
+}
+
+// Allowed return's true if sender of message can use command
+func (spec *CommandSpec) Allowed(m *irc.Message) bool {
+	role := spec.Role
+	if role < permissions.RoleUser {
+		role = permissions.RoleUser
+	}
+	if !role.Validate(m) {
+		return false
+	}
+	return spec.Permission == nil || spec.Permission.Validate(m)
+}
+
+// UsageString return's usage of command with default prefix, eg. ".dice [dice] [-silent]"
+func (spec *CommandSpec) UsageString() string {
+	return spec.UsageFor(COMMAND_DELIMITER)
+}
+
+// UsageFor return's usage of command with prefix
+func (spec *CommandSpec) UsageFor(prefix string) string {
+	if spec.Usage != "" {
+		return prefix + spec.Name + " " + spec.Usage
+	}
+
+	usage := prefix + spec.Name
+	for _, arg := range spec.Args {
+		name := arg.Name
+		if arg.Type == ArgText {
+			name += "..."
+		}
+		if arg.Optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	for _, flag := range spec.Flags {
+		if flag.Type == ArgBool {
+			usage += " [-" + flag.Name + "]"
+		} else {
+			usage += " [-" + flag.Name + "=" + argTypeNames[flag.Type] + "]"
+		}
+	}
+	return usage
+}
+
+// Help return's usage with description, aliases and flags
+func (spec *CommandSpec) Help() string {
+	return spec.HelpFor(COMMAND_DELIMITER)
+}
+
+// HelpFor return's help of command with prefix
+func (spec *CommandSpec) HelpFor(prefix string) string {
+	help := spec.UsageFor(prefix)
+	if spec.Description != "" {
+		help += " - " + spec.Description
+	}
+	if len(spec.Aliases) > 0 {
+		help += " (aliases: " + prefix + strings.Join(spec.Aliases, ", "+prefix) + ")"
+	}
+	for _, flag := range spec.Flags {
+		if flag.Usage != "" {
+			help += "; -" + flag.Name + ": " + flag.Usage
+		}
+	}
+	return help
+}
+
+// names return's name and aliases of command
+func (spec *CommandSpec) names() []string {
+	return append([]string{spec.Name}, spec.Aliases...)
+}
+
+// match return's name used if text (without prefix) invokes the command and the rest of text after it
+func (spec *CommandSpec) match(text string) (string, string, bool) {
+	word, rest := text, ""
+	if i := strings.IndexByte(word, ' '); i > -1 {
+		word, rest = word[:i], word[i+1:]
+	}
+	for _, name := range spec.names() {
+		if strings.EqualFold(word, name) {
+			return name, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// token is one word of arguments with position in text
+type token struct {
+	value string
+	pos   int
+}
+
+// tokenize split's text on spaces, "double quoted" parts are kept together
+func tokenize(text string) []token {
+	var tokens []token
+	for i := 0; i < len(text); {
+		if text[i] == ' ' {
+			i++
+			continue
+		}
+		if text[i] == '"' {
+			if end := strings.IndexByte(text[i+1:], '"'); end > -1 {
+				tokens = append(tokens, token{text[i+1 : i+1+end], i})
+				i += end + 2
+				continue
+			}
+		}
+		end := strings.IndexByte(text[i:], ' ')
+		if end < 0 {
+			end = len(text) - i
+		}
+		tokens = append(tokens, token{text[i : i+end], i})
+		i += end
+	}
+	return tokens
+}
+
+// parseValue converts argument to its type
+func parseValue(t ArgType, value string, features irc.ServerFeatures) (interface{}, error) {
+	switch t {
+	case ArgInt:
+		return strconv.Atoi(value)
+	case ArgFloat:
+		return strconv.ParseFloat(value, 64)
+	case ArgBool:
+		switch strings.ToLower(value) {
+		case "yes", "on", "y":
+			return true, nil
+		case "no", "off", "n":
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	case ArgDuration:
+		return time.ParseDuration(value)
+	case ArgNick:
+		if !features.ValidNick(value) {
+			return nil, errors.New("invalid nick")
+		}
+	case ArgChannel:
+		if !features.ValidChannel(value) {
+			return nil, errors.New("invalid channel")
+		}
+	}
+	return value, nil
+}
+
+// parse fills arguments and flags of command from text after command name
+func (spec *CommandSpec) parse(c *Command, text string) error {
+	features := irc.DefaultFeatures()
+	if c.Server != nil {
+		features = c.Server.Features()
+	}
+
+	c.Args = make(map[string]interface{})
+	c.Flags = make(map[string]interface{})
+
+	var args []token
+	flagsDone := len(spec.Flags) == 0
+	for _, t := range tokenize(text) {
+		if !flagsDone && t.value == "--" {
+			flagsDone = true
+			continue
+		}
+		if flagsDone || len(t.value) < 2 || t.value[0] != '-' || text[t.pos] == '"' || (t.value[1] >= '0' && t.value[1] <= '9') {
+			args = append(args, t)
+			continue
+		}
+
+		name, value, hasValue := t.value[1:], "", false
+		if i := strings.IndexByte(name, '='); i > -1 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		var flag *Flag
+		for i := range spec.Flags {
+			if strings.EqualFold(spec.Flags[i].Name, name) {
+				flag = &spec.Flags[i]
+			}
+		}
+		if flag == nil {
+			return fmt.Errorf("unknown flag -%s", name)
+		}
+		if !hasValue {
+			if flag.Type != ArgBool {
+				return fmt.Errorf("flag -%s needs %s value", flag.Name, argTypeNames[flag.Type])
+			}
+			value = "true"
+		}
+		v, err := parseValue(flag.Type, value, features)
+		if err != nil {
+			return fmt.Errorf("flag -%s needs %s value", flag.Name, argTypeNames[flag.Type])
+		}
+		c.Flags[flag.Name] = v
+	}
+
+	for _, flag := range spec.Flags {
+		if _, ok := c.Flags[flag.Name]; ok {
+			continue
+		}
+		if flag.Default != "" {
+			if v, err := parseValue(flag.Type, flag.Default, features); err == nil {
+				c.Flags[flag.Name] = v
+			}
+		} else if flag.Type == ArgBool {
+			c.Flags[flag.Name] = false
+		}
+	}
+
+	for i, arg := range spec.Args {
+		value := ""
+		if i < len(args) {
+			value = args[i].value
+			if arg.Type == ArgText && len(args) > i+1 { //rest of the line as written
+				value = strings.TrimRight(text[args[i].pos:], " ")
+				args = args[:i+1]
+			}
+		} else if !arg.Optional {
+			return fmt.Errorf("missing %s", arg.Name)
+		} else if arg.Default == "" {
+			continue
+		} else {
+			value = arg.Default
+		}
+
+		v, err := parseValue(arg.Type, value, features)
+		if err != nil {
+			return fmt.Errorf("%s must be %s", arg.Name, argTypeNames[arg.Type])
+		}
+		c.Args[arg.Name] = v
+	}
+
+	if len(args) > len(spec.Args) {
+		return errors.New("too many arguments")
+	}
+
+	return nil
+}
+
+func (c *Command) value(name string) interface{} {
+	if v, ok := c.Args[name]; ok {
+		return v
+	}
+	return c.Flags[name]
+}
+
+// String return's argument or flag as string, empty if missing
+func (c *Command) String(name string) string {
+	switch v := c.value(name).(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Int return's number argument or flag, 0 if missing
+func (c *Command) Int(name string) int {
+	v, _ := c.value(name).(int)
+	return v
+}
+
+// Float return's float argument or flag, 0 if missing
+func (c *Command) Float(name string) float64 {
+	v, _ := c.value(name).(float64)
+	return v
+}
+
+// Bool return's yes/no argument or flag, false if missing
+func (c *Command) Bool(name string) bool {
+	v, _ := c.value(name).(bool)
+	return v
+}
+
+// Duration return's duration argument or flag, 0 if missing
+func (c *Command) Duration(name string) time.Duration {
+	v, _ := c.value(name).(time.Duration)
+	return v
+}
+
+// Has return's true if argument or flag was given (or has default)
+func (c *Command) Has(name string) bool {
+	return c.value(name) != nil
+}
+
+// registry of commands of all modules, for help and name conflicts
+var commandRegistry = struct {
+	sync.RWMutex
+	specs map[string]*CommandSpec //lowercase name or alias -> spec
+}{specs: make(map[string]*CommandSpec)}
+
+func registerCommand(spec *CommandSpec) error {
+	commandRegistry.Lock()
+	defer commandRegistry.Unlock()
+
+	for _, name := range spec.names() {
+		if other, ok := commandRegistry.specs[strings.ToLower(name)]; ok {
+			return errors.New("Command " + COMMAND_DELIMITER + name + " already exist's in module " + other.module + "!")
+		}
+	}
+	for _, name := range spec.names() {
+		commandRegistry.specs[strings.ToLower(name)] = spec
+	}
+	return nil
+}
+
+func unregisterCommand(spec *CommandSpec) {
+	commandRegistry.Lock()
+	defer commandRegistry.Unlock()
+
+	for _, name := range spec.names() {
+		if commandRegistry.specs[strings.ToLower(name)] == spec {
+			delete(commandRegistry.specs, strings.ToLower(name))
+		}
+	}
+}
+
+// FindCommand return's command by name or alias, nil if not found
+func FindCommand(name string) *CommandSpec {
+	commandRegistry.RLock()
+	defer commandRegistry.RUnlock()
+	return commandRegistry.specs[strings.ToLower(strings.TrimPrefix(name, COMMAND_DELIMITER))]
 }
 
+// Commands return's all registered commands sorted by name
+func Commands() []*CommandSpec {
+	commandRegistry.RLock()
+	defer commandRegistry.RUnlock()
+
+	var specs []*CommandSpec
+	for key, spec := range commandRegistry.specs {
+		if strings.EqualFold(key, spec.Name) {
+			specs = append(specs, spec)
+		}
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// AddCommand register's simple command, whole text after command is "text" argument
 func (m *Module) AddCommand(name string, f func(*Command), permission permissions.Permission) error {
-	name = COMMAND_DELIMITER + name
+	return m.AddCommandSpec(CommandSpec{
+		Name:       name,
+		Args:       []Arg{{Name: "text", Type: ArgText, Optional: true}},
+		Permission: permission,
+	}, f)
+}
+
+// AddCommandSpec register's command described by spec, handler get's parsed and validated arguments
+func (m *Module) AddCommandSpec(spec CommandSpec, f func(*Command)) error {
+	if spec.Name == "" {
+		return errors.New("Command needs name!")
+	}
+	for i, arg := range spec.Args {
+		if arg.Type == ArgText && i != len(spec.Args)-1 {
+			return errors.New("Text argument must be last!")
+		}
+	}
+
+	key := COMMAND_DELIMITER + strings.ToLower(spec.Name)
+	if _, ok := m.handlers[key]; ok {
+		return errors.New("Command with same name already exist's!")
+	}
+
+	s := &spec
+	s.module = m.name
+	if err := registerCommand(s); err != nil {
+		return err
+	}
+
 	wrap := func(message *irc.Message) {
 		switch message.Command {
-		case "PRIVMSG", "NOTICE":
-			nick := message.Server.CurrentNick()
-			text := strings.Join(message.Arguments[1:], " ")
-			if message.Arguments[0] != nick { //dot command from channel
-				if strings.HasPrefix(strings.Trim(text, " "), name) {
-					f(&Command{message, text})
-				}
+		case "PRIVMSG": //notices are never answered
+			prefix, text, ok := splitCommand(m.config, message)
+			if !ok {
+				return
+			}
+			name, rest, ok := s.match(text)
+			if !ok || !s.Allowed(message) {
+				return
+			}
+			if rateLimited(m.config, m.name+"/"+s.Name, s.Limits, message) {
+				return
 			}
-		}
-	}
 
-	if _, ok := m.handlers[name]; ok {
-		return errors.New("Response with same regexp already exist's!")
+			c := &Command{Message: message, Text: strings.Join(message.Arguments[1:], " "), Prefix: prefix, Name: name, Spec: s}
+			if err := s.parse(c, rest); err != nil {
+				message.Mentionf("%s! usage: %s", err, s.UsageFor(prefix))
+				return
+			}
+			f(c)
+		}
 	}
 
-	m.handlers[name] = m.connection.AddHandler(wrap, permission)
+	m.handlers[key] = m.connection.AddScopedHandler(m.name, wrap, nil)
+	m.commands = append(m.commands, s)
 	return nil
 }
 
 func (m *Module) RemoveCommand(name string) error {
-	name = COMMAND_DELIMITER + name
+	key := COMMAND_DELIMITER + strings.ToLower(name)
 
 	if len(m.handlers) < 0 {
 		return errors.New("This module has no commands!")
 	}
 
-	kill, ok := m.handlers[name]
+	kill, ok := m.handlers[key]
 	if !ok {
 		return errors.New("This command is not defined")
 	}
 
 	kill <- true
-	delete(m.handlers, name)
+	delete(m.handlers, key)
+
+	for i, spec := range m.commands {
+		if strings.EqualFold(spec.Name, name) {
+			unregisterCommand(spec)
+			m.commands = append(m.commands[:i], m.commands[i+1:]...)
+			break
+		}
+	}
 
 	return nil
 }
+
+// CommandPrefixes return's command prefixes used in channel (private messages if empty), longest first
+func CommandPrefixes(conf *config.Configuration, features irc.ServerFeatures, channel string) []string {
+	prefixes := []string{COMMAND_DELIMITER}
+	if conf != nil {
+		conf.RLock()
+		if len(conf.CommandPrefixes) > 0 {
+			prefixes = conf.CommandPrefixes
+		}
+		if channel != "" {
+			for name, override := range conf.ChannelCommandPrefixes {
+				if len(override) > 0 && features.EqualFold(name, channel) {
+					prefixes = override
+				}
+			}
+		}
+		conf.RUnlock()
+	}
+
+	sorted := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			sorted = append(sorted, prefix)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return sorted
+}
+
+// splitCommand return's prefix and text after it if message invokes some command
+func splitCommand(conf *config.Configuration, message *irc.Message) (string, string, bool) {
+	if len(message.Arguments) < 2 {
+		return "", "", false
+	}
+	features := message.Server.Features()
+	text := strings.TrimLeft(strings.Join(message.Arguments[1:], " "), " ")
+	prefixes := CommandPrefixes(conf, features, message.Channel)
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(text, prefix) && len(text) > len(prefix) && text[len(prefix)] != ' ' {
+			return prefix, text[len(prefix):], true
+		}
+	}
+
+	onNick := false
+	if conf != nil {
+		conf.RLock()
+		onNick = conf.CommandOnNick
+		conf.RUnlock()
+	}
+	if nick := message.Server.CurrentNick(); onNick && len(text) > len(nick)+1 && features.EqualFold(text[:len(nick)], nick) && strings.IndexByte(":, ", text[len(nick)]) > -1 {
+		rest := strings.TrimLeft(text[len(nick)+1:], " ")
+		for _, prefix := range prefixes { //"dashy: .dice" is fine too
+			if strings.HasPrefix(rest, prefix) {
+				rest = rest[len(prefix):]
+				break
+			}
+		}
+		if rest != "" {
+			return nick + ": ", rest, true
+		}
+	}
+
+	return "", "", false
+}
+
+// helpHandler answers help [command] with commands the sender can use
+func helpHandler(conf *config.Configuration, message *irc.Message) {
+	if message.Command != "PRIVMSG" {
+		return
+	}
+	prefix, text, ok := splitCommand(conf, message)
+	if !ok {
+		return
+	}
+
+	help := &CommandSpec{Name: "help"}
+	_, rest, ok := help.match(text)
+	if !ok {
+		return
+	}
+
+	if name := strings.TrimSpace(rest); name != "" {
+		spec := FindCommand(strings.TrimPrefix(name, prefix))
+		if spec == nil || !spec.Allowed(message) {
+			message.Mention("i don't know command " + name + "!")
+			return
+		}
+		message.Respond(spec.HelpFor(prefix))
+		return
+	}
+
+	var names []string
+	for _, spec := range Commands() {
+		if spec.Allowed(message) {
+			names = append(names, prefix+spec.Name)
+		}
+	}
+	if len(names) == 0 {
+		message.Mention("there are no commands for you!")
+		return
+	}
+	message.Respond("commands: " + strings.Join(names, ", ") + " (" + prefix + "help <command> for more)")
+}
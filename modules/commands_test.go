@@ -0,0 +1,195 @@
+package modules_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/ignore"
+	"github.com/natrim/grainbot/irc/irctest"
+	. "github.com/natrim/grainbot/modules"
+)
+
+// startModule return's module connected to fake server, stop cleans up everything
+func startModule(t *testing.T, name string) (*Module, *config.Configuration, *irctest.Server, func()) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := irctest.NewConnection()
+	conf := config.NewConfiguration()
+	conf.ACLFile = filepath.Join(dir, "acl.json")
+	conf.IgnoreFile = filepath.Join(dir, "ignore.json")
+	conf.StoreFile = filepath.Join(dir, "grainbot.db")
+	Start(conn, conf)
+
+	mod := NewModule(name, nil, nil)
+	mod.Initialize(conn, conf, name)
+	mod.Activate()
+
+	server := irctest.Connect(t, conn)
+
+	return mod, conf, server, func() {
+		conn.Disconnect()
+		mod.Deactivate()
+		Stop(conn, conf)
+		CloseStore()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCommandSpec(t *testing.T) {
+	mod, conf, server, stop := startModule(t, "test")
+	defer stop()
+
+	err := mod.AddCommandSpec(CommandSpec{
+		Name:        "say",
+		Aliases:     []string{"echo"},
+		Description: "says text",
+		Args:        []Arg{{Name: "times", Type: ArgInt}, {Name: "text", Type: ArgText}},
+		Flags:       []Flag{{Name: "loud", Type: ArgBool}},
+	}, func(c *Command) {
+		c.Respondf("%d %v %s", c.Int("times"), c.Bool("loud"), c.String("text"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.AddCommandSpec(CommandSpec{Name: "hug", Limits: Limits{User: Cooldown(time.Hour)}}, func(c *Command) {
+		c.Respond("*hugs*")
+	})
+
+	if err := mod.AddCommand("echo", func(c *Command) {}, nil); err == nil {
+		t.Error("Alias registered twice")
+	}
+
+	expectIn := func(target, message, reply string) {
+		server.Send(":Twilight!twi@library PRIVMSG " + target + " :" + message)
+		timeout := time.Second
+		if reply == "" {
+			timeout = 100 * time.Millisecond
+		}
+		line, ok := server.Wait(timeout, "PRIVMSG", "NOTICE")
+		if ok && line != reply {
+			t.Errorf("%q got reply %q, expected %q", message, line, reply)
+		} else if !ok && reply != "" {
+			t.Errorf("%q got no reply", message)
+		}
+	}
+
+	expect := func(message, reply string) {
+		expectIn("#pony", message, reply)
+	}
+
+	expect(".sayfoo 2 bar", "")
+	expect(".say 2 -loud hello  there", "PRIVMSG #pony :2 true hello  there")
+	expect(".ECHO 3 \"quoted\"", "PRIVMSG #pony :3 false quoted")
+	expect(".say two x", "PRIVMSG #pony :Twilight, times must be number! usage: .say <times> <text...> [-loud]")
+	expect(".say 1 x -quiet", "PRIVMSG #pony :Twilight, unknown flag -quiet! usage: .say <times> <text...> [-loud]")
+	expect(".help", "PRIVMSG #pony :commands: .hug, .say ("+".help <command> for more)")
+	expect(".help echo", "PRIVMSG #pony :.say <times> <text...> [-loud] - says text (aliases: .echo)")
+
+	expectIn("dashy", ".say 1 hi", "PRIVMSG Twilight :1 false hi")
+	server.Send(":Twilight!twi@library NOTICE dashy :.say 1 notice")
+	server.Send(":Twilight!twi@library NOTICE #pony :.say 2 notice")
+	expect(".say 3 hi", "PRIVMSG #pony :3 false hi")
+
+	conf.Lock()
+	conf.CommandPrefixes = config.StringList{"!", "!!"}
+	conf.ChannelCommandPrefixes = map[string]config.StringList{"#Canterlot": {"~"}}
+	conf.CommandOnNick = true
+	conf.Unlock()
+	expect(".say 1 hi", "")
+	expect("!!say 1 hi", "PRIVMSG #pony :1 false hi")
+	expectIn("#canterlot", "!say 1 hi", "")
+	expectIn("#canterlot", "~say x", "PRIVMSG #canterlot :Twilight, times must be number! usage: ~say <times> <text...> [-loud]")
+	expect("Dashy: say 4 hi", "PRIVMSG #pony :4 false hi")
+	expect("dashy, !say 5 hi", "PRIVMSG #pony :5 false hi")
+
+	conf.Lock()
+	conf.SlowDownNotice = "slow down, {nick}!"
+	conf.Unlock()
+	expect("!hug", "PRIVMSG #pony :*hugs*")
+	expect("!hug", "NOTICE Twilight :slow down, Twilight!")
+	expect("!hug", "")
+	expectIn("#canterlot", "~hug", "")
+}
+
+func TestRateLimitUsers(t *testing.T) {
+	mod, conf, server, stop := startModule(t, "limits")
+	defer stop()
+
+	conf.Lock()
+	conf.UserRate = 3
+	conf.Unlock()
+
+	mod.AddCommandSpec(CommandSpec{Name: "boop", Limits: Limits{Command: Limit{Every: time.Hour, Burst: 2}, User: Cooldown(time.Hour)}}, func(c *Command) {
+		c.Respondf("boop %s", c.Nick)
+	})
+	mod.AddCommand("cake", func(c *Command) {
+		c.Respondf("cake %s", c.Nick)
+	}, nil)
+
+	say := func(nick, message string) {
+		server.Send(":" + nick + "!" + nick + "@ponyville PRIVMSG #pony :" + message)
+	}
+	expect := func(reply string) {
+		line, ok := server.Wait(time.Second, "PRIVMSG")
+		if !ok || line != "PRIVMSG #pony :"+reply {
+			t.Errorf("Expected %q, got %q", reply, line)
+		}
+	}
+
+	say("Pinkie", ".boop")
+	expect("boop Pinkie")
+
+	//refused spam takes nothing from shared command bucket and user rate
+	for i := 0; i < 5; i++ {
+		say("Pinkie", ".boop")
+	}
+	say("Fluttershy", ".boop")
+	expect("boop Fluttershy")
+	say("Pinkie", ".cake")
+	expect("cake Pinkie")
+
+	if line, ok := server.Wait(100*time.Millisecond, "PRIVMSG"); ok {
+		t.Errorf("Spam answered: %q", line)
+	}
+}
+
+func TestIgnoredUser(t *testing.T) {
+	mod, _, server, stop := startModule(t, "nuzzles")
+	defer stop()
+
+	var nuzzled []string
+	mod.AddCommand("nuzzle", func(c *Command) {
+		nuzzled = append(nuzzled, c.Nick)
+		c.Respondf("*nuzzles %s*", c.Nick)
+	}, nil)
+
+	ignore.DefaultList.Add("Derpy", "", 0)
+	ignore.DefaultList.Add("*!*@everfree", "nuzzles", 0)
+	ignore.DefaultList.Add("Trixie", "music", 0)
+
+	say := func(nick, host string) {
+		server.Send(":" + nick + "!" + nick + "@" + host + " PRIVMSG #pony :.nuzzle")
+	}
+	say("Derpy", "cloudsdale")
+	say("Zecora", "everfree")
+	say("Trixie", "wagon")
+	if line, _ := server.Wait(time.Second, "PRIVMSG"); line != "PRIVMSG #pony :*nuzzles Trixie*" {
+		t.Errorf("Wrong reply: %q", line)
+	}
+
+	ignore.DefaultList.Remove("derpy", "")
+	say("Derpy", "cloudsdale")
+	if line, _ := server.Wait(time.Second, "PRIVMSG"); line != "PRIVMSG #pony :*nuzzles Derpy*" {
+		t.Errorf("Wrong reply: %q", line)
+	}
+	if len(nuzzled) != 2 {
+		t.Error("Handler called for ignored users: ", nuzzled)
+	}
+}
@@ -35,7 +35,11 @@ func InitCoin(mod *modules.Module) {
 	mod.AddResponse(coinreg, func(r *modules.Response) {
 		throwCoin(r.Message)
 	}, nil)
-	mod.AddCommand("coin", func(r *modules.Command) {
+	mod.AddCommandSpec(modules.CommandSpec{
+		Name:        "coin",
+		Aliases:     []string{"flip"},
+		Description: "throws a coin",
+	}, func(r *modules.Command) {
 		throwCoin(r.Message)
-	}, nil)
+	})
 }
@@ -36,6 +36,11 @@ func diceRoll(dice, faces int) string {
 
 // handle the irc message
 func throwDice(r *irc.Message, dice, faces int) {
+	if dice < 1 || dice > 100 || faces < 1 || faces > 1000 {
+		r.Mention("i have only 100 dice with up to 1000 faces!")
+		return
+	}
+
 	r.Action("kicks the dice to you...")
 
 	result := diceRoll(dice, faces)
@@ -46,11 +51,14 @@ func throwDice(r *irc.Message, dice, faces int) {
 
 // precompile the command regexp
 var dicereg = regexp.MustCompile("^((throw|kick|roll) )?dice( ([0-9]+d[0-9]+))?$")
-var dicesubreg = regexp.MustCompile("dice ([0-9]+d[0-9]+)")
+var dicesubreg = regexp.MustCompile("^([0-9]+)d([0-9]+)$")
+
+// few rolls at once, then one every few seconds
+var diceLimits = modules.Limits{User: modules.Limit{Every: 5 * time.Second, Burst: 3}}
 
 // InitDice register's dice commands on module load
 func InitDice(mod *modules.Module) {
-	mod.AddResponse(dicereg, func(r *modules.Response) {
+	mod.AddLimitedResponse(dicereg, func(r *modules.Response) {
 		if len(r.Matches)-1 >= 4 && r.Matches[4] != "" {
 			dice := strings.Split(r.Matches[4], "d")
 			dices, _ := strconv.Atoi(dice[0])
@@ -59,16 +67,21 @@ func InitDice(mod *modules.Module) {
 		} else {
 			throwDice(r.Message, 1, 6)
 		}
-	}, nil)
-	mod.AddCommand("dice", func(r *modules.Command) {
-		matches := dicesubreg.FindStringSubmatch(r.Text)
-		if len(matches)-1 >= 1 && matches[1] != "" {
-			dice := strings.Split(matches[1], "d")
-			dices, _ := strconv.Atoi(dice[0])
-			faces, _ := strconv.Atoi(dice[1])
-			throwDice(r.Message, dices, faces)
-		} else {
-			throwDice(r.Message, 1, 6)
+	}, nil, diceLimits)
+	mod.AddCommandSpec(modules.CommandSpec{
+		Name:        "dice",
+		Aliases:     []string{"roll"},
+		Description: "rolls the dice, eg. 2d6 is two six-sided dice",
+		Args:        []modules.Arg{{Name: "dice", Optional: true, Default: "1d6"}},
+		Limits:      diceLimits,
+	}, func(r *modules.Command) {
+		matches := dicesubreg.FindStringSubmatch(r.String("dice"))
+		if matches == nil {
+			r.Mentionf("dice must be like 2d6! usage: %s", r.Spec.UsageFor(r.Prefix))
+			return
 		}
-	}, nil)
+		dices, _ := strconv.Atoi(matches[1])
+		faces, _ := strconv.Atoi(matches[2])
+		throwDice(r.Message, dices, faces)
+	})
 }
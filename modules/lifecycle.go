@@ -0,0 +1,146 @@
+package modules
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/natrim/grainbot/config"
+)
+
+// Hook is lifecycle event of bot delivered to modules
+type Hook int
+
+const (
+	HookConnect       Hook = iota //connected to server
+	HookDisconnect                //connection lost or closed
+	HookRegistered                //server accepted us (001)
+	HookConfigReload              //config was reloaded from file
+	HookBeforeRestart             //bot is going to restart
+)
+
+var hookNames = []string{"OnConnect", "OnDisconnect", "OnRegistered", "OnConfigReload", "OnBeforeRestart"}
+
+func (h Hook) String() string {
+	if h < 0 || int(h) >= len(hookNames) {
+		return "unknown"
+	}
+	return hookNames[h]
+}
+
+// Reverse return's true if hook runs from modules depending on others to their dependencies
+func (h Hook) Reverse() bool {
+	return h == HookDisconnect || h == HookBeforeRestart
+}
+
+// DependsOn declares modules which must be activated before this one
+func (m *Module) DependsOn(names ...string) *Module {
+	m.Depends = append(m.Depends, names...)
+	return m
+}
+
+// RunHook call's hook function of active module
+func (m *Module) RunHook(h Hook) {
+	if !m.IsActive() {
+		return
+	}
+
+	var f func(*Module)
+	switch h {
+	case HookConnect:
+		f = m.OnConnect
+	case HookDisconnect:
+		f = m.OnDisconnect
+	case HookRegistered:
+		f = m.OnRegistered
+	case HookConfigReload:
+		f = m.OnConfigReload
+	case HookBeforeRestart:
+		f = m.OnBeforeRestart
+	}
+	if f == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Module \"%s\" %s failure: %s", m.name, h, r)
+		}
+	}()
+	f(m)
+}
+
+// ConfigChanged call's OnConfigChange of active module
+func (m *Module) ConfigChanged(changes config.Changes) {
+	if m.OnConfigChange == nil || len(changes) == 0 || !m.IsActive() {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Module \"%s\" OnConfigChange failure: %s", m.name, r)
+		}
+	}()
+	m.OnConfigChange(m, changes)
+}
+
+// SortModules order's modules so dependencies go before modules needing them
+func SortModules(mods []*Module) ([]*Module, error) {
+	byName := make(map[string]*Module, len(mods))
+	names := make([]string, 0, len(mods))
+	for _, mod := range mods {
+		name := strings.ToLower(mod.Name())
+		byName[name] = mod
+		names = append(names, name)
+	}
+	sort.Strings(names) //same order every start
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(mods))
+	sorted := make([]*Module, 0, len(mods))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					path = path[i:]
+					break
+				}
+			}
+			return errors.New("Module dependency cycle: " + strings.Join(append(path, name), " -> ") + "!")
+		}
+
+		state[name] = visiting
+		mod := byName[name]
+		for _, dep := range mod.Depends {
+			dep = strings.ToLower(dep)
+			if _, ok := byName[dep]; !ok {
+				return errors.New("Module \"" + mod.Name() + "\" depends on unknown module \"" + dep + "\"!")
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		sorted = append(sorted, mod)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,65 @@
+package modules_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc"
+	. "github.com/natrim/grainbot/modules"
+)
+
+func newTestModule(name string, depends ...string) *Module {
+	mod := NewModule(name, nil, nil).DependsOn(depends...)
+	mod.Initialize(irc.NewConnection("dashy", "grainbot", "Botus Grainus"), config.NewConfiguration(), name)
+	return mod
+}
+
+func TestSortModules(t *testing.T) {
+	sorted, err := SortModules([]*Module{
+		newTestModule("help", "dice", "Coin"),
+		newTestModule("dice", "system"),
+		newTestModule("coin"),
+		newTestModule("system"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, mod := range sorted {
+		names = append(names, mod.Name())
+	}
+	if strings.Join(names, ",") != "coin,system,dice,help" {
+		t.Error("Wrong order: ", names)
+	}
+
+	_, err = SortModules([]*Module{newTestModule("a", "b"), newTestModule("b", "c"), newTestModule("c", "b")})
+	if err == nil || !strings.Contains(err.Error(), "b -> c -> b") {
+		t.Error("Cycle not detected: ", err)
+	}
+
+	if _, err = SortModules([]*Module{newTestModule("a", "pony")}); err == nil {
+		t.Error("Unknown dependency not detected")
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	mod := newTestModule("pony")
+	called := 0
+	mod.OnRegistered = func(*Module) { called++ }
+	mod.OnConnect = func(*Module) { panic("buck!") }
+
+	mod.RunHook(HookRegistered)
+	if called != 0 {
+		t.Error("Hook of inactive module called")
+	}
+
+	mod.Activate()
+	mod.RunHook(HookRegistered)
+	mod.RunHook(HookConnect) //panic is recovered
+	mod.RunHook(HookDisconnect)
+	if called != 1 {
+		t.Error("Hook not called")
+	}
+}
@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"strings"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc"
+)
+
+// Manager controls modules at runtime (implemented by bot)
+type Manager interface {
+	Modules() []string //names of registered modules
+	ModuleActive(name string) bool
+	EnableModule(name string) error
+	DisableModule(name string) error
+	ReloadModule(name string) error
+	ReloadConfig() (config.Changes, error) //load config file again and notify modules
+}
+
+var manager Manager
+
+// SetManager set's manager used by modules
+func SetManager(m Manager) {
+	manager = m
+}
+
+// GetManager return's manager of modules, nil if bot did not set any
+func GetManager() Manager {
+	return manager
+}
+
+// DisabledIn return's true if module is disabled in channel by config
+func DisabledIn(conf *config.Configuration, features irc.ServerFeatures, module, channel string) bool {
+	conf.RLock()
+	defer conf.RUnlock()
+
+	for name, disabled := range conf.ChannelDisabledModules {
+		if !features.EqualFold(name, channel) {
+			continue
+		}
+		for _, d := range disabled {
+			if strings.EqualFold(d, module) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scopeFilter hides messages from modules disabled in channel and from ignored users
+type scopeFilter struct {
+	conf   *config.Configuration
+	ignore irc.Ignorer
+}
+
+func (f *scopeFilter) Ignored(m *irc.Message, scope string) bool {
+	if scope != "" && m.Channel != "" && m.Server != nil && DisabledIn(f.conf, m.Server.Features(), scope, m.Channel) {
+		return true
+	}
+	return f.ignore != nil && f.ignore.Ignored(m, scope)
+}
+
+// SetDisabledIn disable's or enable's module in channel by config
+func SetDisabledIn(conf *config.Configuration, features irc.ServerFeatures, module, channel string, disabled bool) {
+	if DisabledIn(conf, features, module, channel) == disabled {
+		return
+	}
+
+	conf.Lock()
+	defer conf.Unlock()
+
+	if conf.ChannelDisabledModules == nil {
+		conf.ChannelDisabledModules = make(map[string]config.StringList)
+	}
+	key := channel
+	for name := range conf.ChannelDisabledModules {
+		if features.EqualFold(name, channel) {
+			key = name
+		}
+	}
+
+	if disabled {
+		conf.ChannelDisabledModules[key] = append(conf.ChannelDisabledModules[key], strings.ToLower(module))
+		return
+	}
+
+	list := config.StringList{}
+	for _, d := range conf.ChannelDisabledModules[key] {
+		if !strings.EqualFold(d, module) {
+			list = append(list, d)
+		}
+	}
+	if len(list) == 0 {
+		delete(conf.ChannelDisabledModules, key)
+	} else {
+		conf.ChannelDisabledModules[key] = list
+	}
+}
@@ -0,0 +1,42 @@
+package modules_test
+
+import (
+	"testing"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc"
+	. "github.com/natrim/grainbot/modules"
+)
+
+func TestModuleActivation(t *testing.T) {
+	inits, halts := 0, 0
+	mod := NewModule("pony", func(*Module) { inits++ }, func(*Module) { halts++ })
+	mod.Initialize(irc.NewConnection("dashy", "grainbot", "Botus Grainus"), config.NewConfiguration(), "pony")
+
+	mod.Activate()
+	mod.Activate()
+	if !mod.IsActive() || inits != 1 {
+		t.Error("Module activated twice")
+	}
+
+	mod.Deactivate()
+	mod.Deactivate()
+	if mod.IsActive() || halts != 1 {
+		t.Error("Module deactivated twice")
+	}
+}
+
+func TestDisabledIn(t *testing.T) {
+	conf := config.NewConfiguration()
+	features := irc.DefaultFeatures()
+
+	SetDisabledIn(conf, features, "Dice", "#Pony", true)
+	if !DisabledIn(conf, features, "dice", "#pony") || DisabledIn(conf, features, "dice", "#canterlot") || DisabledIn(conf, features, "coin", "#pony") {
+		t.Error("Module not disabled only in channel")
+	}
+
+	SetDisabledIn(conf, features, "dice", "#PONY", false)
+	if DisabledIn(conf, features, "dice", "#pony") || len(conf.ChannelDisabledModules) != 0 {
+		t.Error("Module not enabled again: ", conf.ChannelDisabledModules)
+	}
+}
@@ -2,7 +2,14 @@ package modules
 
 import (
 	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/ignore"
 	"github.com/natrim/grainbot/irc"
 	"github.com/natrim/grainbot/permissions"
 )
@@ -13,23 +20,138 @@ func NewModule(name string, init func(*Module), halt func(*Module)) *Module {
 
 // Start run's before module loading - only once per bot live
 func Start(conn *irc.Connection, conf *config.Configuration) {
-	//put owner nick in permission
-	ownerNick = conf.Owner
+	//put owner masks in permission
+	loadOwner(conn, conf)
+
+	//masks are compared by case mapping of server
+	fold := func(s string) string {
+		return conn.Features().Fold(s)
+	}
+
+	//load role grants
+	permissions.DefaultACL.Owner = &OwnerPermission{}
+	permissions.DefaultACL.Fold = fold
+	loadACL(conf)
+
+	//load ignore list, owner is never ignored
+	ignore.DefaultList.Exempt = &OwnerPermission{}
+	ignore.DefaultList.Fold = fold
+	loadIgnore(conf)
+	conn.Ignore = &scopeFilter{conf: conf, ignore: ignore.DefaultList}
+
+	//open module stores
+	if err := OpenStore(dataFile(conf, conf.StoreFile, "grainbot.db")); err != nil {
+		log.Error(err)
+	}
+
+	//built-in .help
+	helpKill = conn.AddScopedHandler("help", func(message *irc.Message) {
+		helpHandler(conf, message)
+	}, nil)
+}
+
+// Reload run's after config reload, before modules are notified
+func Reload(conn *irc.Connection, conf *config.Configuration, changes config.Changes) {
+	if changes.Has("Owner") {
+		loadOwner(conn, conf)
+	}
+	if changes.Has("ACLFile") {
+		loadACL(conf)
+	}
+	if changes.Has("IgnoreFile") {
+		loadIgnore(conf)
+	}
+	if changes.Has("StoreFile") {
+		log.Warn("Store file change needs restart!")
+	}
+}
+
+func loadOwner(conn *irc.Connection, conf *config.Configuration) {
+	conf.RLock()
+	masks := append([]string(nil), conf.Owner...)
+	conf.RUnlock()
+
+	for _, mask := range masks {
+		if !strings.ContainsAny(mask, "!@") {
+			log.Warnf("Owner \"%s\" is identified by nick only, anyone using the nick is owner!", mask)
+		}
+	}
+	ownerLock.Lock()
+	defer ownerLock.Unlock()
+	owner.Masks = masks
+	owner.Fold = func(s string) string {
+		return conn.Features().Fold(s)
+	}
+}
+
+func loadACL(conf *config.Configuration) {
+	if err := permissions.DefaultACL.Load(dataFile(conf, conf.ACLFile, "acl.json")); err != nil {
+		log.Error(err)
+	}
+}
+
+func loadIgnore(conf *config.Configuration) {
+	if err := ignore.DefaultList.Load(dataFile(conf, conf.IgnoreFile, "ignore.json")); err != nil {
+		log.Error(err)
+	}
+}
+
+// dataFile return's path of bot data file, relative paths are in config directory
+func dataFile(conf *config.Configuration, file, def string) string {
+	if file == "" {
+		file = def
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(conf.Dir(), file)
+	}
+	return file
 }
 
 // Stop run's before module unloading - only once per bot live
 func Stop(conn *irc.Connection, conf *config.Configuration) {
+	if helpKill != nil {
+		helpKill <- true
+		helpKill = nil
+	}
+
+	//limits start over on next start
+	rateLimiter.Lock()
+	rateLimiter.buckets = make(map[string]*bucket)
+	rateLimiter.Unlock()
 }
 
+var helpKill chan bool
+
 type Module struct {
 	Init func(*Module)
 	Halt func(*Module)
 	name string
 
+	Depends []string //names of modules activated before this one
+
+	//optional lifecycle hooks, see Hook
+	OnConnect       func(*Module)
+	OnDisconnect    func(*Module)
+	OnRegistered    func(*Module)
+	OnConfigReload  func(*Module)
+	OnBeforeRestart func(*Module)
+
+	OnConfigChange func(*Module, config.Changes) //after config reload which changed something
+
 	connection *irc.Connection
 	config     *config.Configuration
 
 	handlers map[string]chan bool
+	commands []*CommandSpec
+
+	active     bool
+	lock       sync.Mutex
+	toggleLock sync.Mutex //serializes Activate and Deactivate, callbacks run without lock
+
+	jobs        map[int]*Job
+	jobHandlers map[string]func([]byte)
+	jobsOpen    bool
+	jobLock     sync.Mutex
 }
 
 func (m *Module) Initialize(conn *irc.Connection, conf *config.Configuration, name string) {
@@ -51,17 +173,59 @@ func (m *Module) GetConnection() *irc.Connection {
 	return m.connection
 }
 
+// RequestCap declares IRCv3 capabilities the module want's enabled
+func (m *Module) RequestCap(names ...string) {
+	m.connection.RequestCap(names...)
+}
+
+// IsActive return's true if module is activated
+func (m *Module) IsActive() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.active
+}
+
+// Activate run's module init, does nothing if already active
 func (m *Module) Activate() {
+	m.toggleLock.Lock()
+	defer m.toggleLock.Unlock()
+
+	m.lock.Lock()
+	if m.active {
+		m.lock.Unlock()
+		return
+	}
+	m.active = true
+	m.lock.Unlock()
+
+	m.openJobs()
 	if m.Init != nil {
 		m.Init(m)
 	}
 }
 
+// Deactivate remove's all handlers of module and run's halt, does nothing if not active
 func (m *Module) Deactivate() {
+	m.toggleLock.Lock()
+	defer m.toggleLock.Unlock()
+
+	m.lock.Lock()
+	if !m.active {
+		m.lock.Unlock()
+		return
+	}
+	m.active = false
+	m.lock.Unlock()
+
 	for name, kill := range m.handlers {
 		kill <- true
 		delete(m.handlers, name)
 	}
+	for _, spec := range m.commands {
+		unregisterCommand(spec)
+	}
+	m.commands = nil
+	m.closeJobs()
 
 	if m.Halt != nil {
 		m.Halt(m)
@@ -73,7 +237,7 @@ func (m *Module) AddIrcMessageHandler(name string, f func(*irc.Message), permiss
 		return errors.New("Handler with same name already exist's!")
 	}
 
-	m.handlers[name] = m.connection.AddHandler(f, permission)
+	m.handlers[name] = m.connection.AddScopedHandler(m.name, f, permission)
 	return nil
 }
 
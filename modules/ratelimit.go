@@ -0,0 +1,151 @@
+package modules
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/permissions"
+)
+
+// Limit is token bucket, Burst uses are allowed at once and one is refilled every Every
+type Limit struct {
+	Every time.Duration
+	Burst int
+}
+
+// Cooldown is limit allowing one use per duration
+func Cooldown(d time.Duration) Limit {
+	return Limit{Every: d, Burst: 1}
+}
+
+func (l Limit) enabled() bool {
+	return l.Every > 0
+}
+
+// Limits of command or response, zero limit is unlimited
+type Limits struct {
+	Command Limit //for everypony together
+	User    Limit //for each user
+	Channel Limit //for each channel (private messages are per user)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	warned bool //slow down notice was sent
+}
+
+// limiter keeps token buckets by key
+type limiter struct {
+	sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+var rateLimiter = &limiter{buckets: make(map[string]*bucket)}
+
+// check is limit of one bucket
+type check struct {
+	key   string
+	limit Limit
+}
+
+// allow takes token from every bucket only if all of them have one, return's if allowed and if user should be told to slow down
+func (l *limiter) allow(checks []check, now time.Time) (bool, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.calls++
+	if l.calls%1000 == 0 {
+		l.prune(now)
+	}
+
+	var buckets []*bucket
+	for _, check := range checks {
+		if !check.limit.enabled() {
+			continue
+		}
+		burst := float64(check.limit.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+
+		b, ok := l.buckets[check.key]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			l.buckets[check.key] = b
+		}
+
+		b.tokens += float64(now.Sub(b.last)) / float64(check.limit.Every)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+
+		if b.tokens < 1 { //nothing is taken from other buckets
+			warn := !b.warned
+			b.warned = true
+			return false, warn
+		}
+		buckets = append(buckets, b)
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+		b.warned = false
+	}
+	return true, false
+}
+
+// prune forget's buckets unused for long time, called with lock held
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > time.Hour {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimited checks global user rate and limits of command, tell's user to slow down once
+func rateLimited(conf *config.Configuration, name string, limits Limits, m *irc.Message) bool {
+	if permissions.RoleOwner.Validate(m) { //owner is never limited
+		return false
+	}
+
+	userRate, notice := 0, ""
+	if conf != nil {
+		conf.RLock()
+		userRate, notice = conf.UserRate, conf.SlowDownNotice
+		conf.RUnlock()
+	}
+
+	features := irc.DefaultFeatures()
+	if m.Server != nil {
+		features = m.Server.Features()
+	}
+	user := features.Fold(m.User + "@" + m.Host)
+	channel := user
+	if m.Channel != "" {
+		channel = features.Fold(m.Channel)
+	}
+
+	var checks []check
+	if userRate > 0 {
+		checks = append(checks, check{"user:" + user, Limit{Every: time.Minute / time.Duration(userRate), Burst: userRate}})
+	}
+	checks = append(checks,
+		check{"command:" + name, limits.Command},
+		check{"user:" + name + ":" + user, limits.User},
+		check{"channel:" + name + ":" + channel, limits.Channel},
+	)
+
+	ok, warn := rateLimiter.allow(checks, time.Now())
+	if !ok && warn && notice != "" && m.Nick != "" && m.Server != nil {
+		m.Server.Notice(m.Nick, strings.Replace(notice, "{nick}", m.Nick, -1))
+	}
+
+	return !ok
+}
@@ -18,14 +18,22 @@ type Response struct {
 }
 
 func (m *Module) AddResponse(reg *regexp.Regexp, f func(*Response), permission permissions.Permission) error {
+	return m.AddLimitedResponse(reg, f, permission, Limits{})
+}
+
+// AddLimitedResponse add's response with cooldowns and rate limits
+func (m *Module) AddLimitedResponse(reg *regexp.Regexp, f func(*Response), permission permissions.Permission, limits Limits) error {
 	name := reg.String()
+	limited := func(message *irc.Message) bool {
+		return rateLimited(m.config, m.name+"/"+name, limits, message)
+	}
 	wrap := func(message *irc.Message) {
 		switch message.Command {
-		case "PRIVMSG", "NOTICE":
+		case "PRIVMSG": //notices are never answered
 			nick := message.Server.CurrentNick()
 			text := strings.Join(message.Arguments[1:], " ")
 			if message.Arguments[0] == nick { //direct privmsg
-				if reg.MatchString(strings.Trim(text, " ")) {
+				if reg.MatchString(strings.Trim(text, " ")) && !limited(message) {
 					f(&Response{message, text, reg.FindStringSubmatch(text)})
 				}
 			} else { //asked from channel
@@ -36,7 +44,7 @@ func (m *Module) AddResponse(reg *regexp.Regexp, f func(*Response), permission p
 					nl := len(nick) + 1
 					if len(text) > nl {
 						just_text := strings.Trim(text[nl:], " ")
-						if reg.MatchString(just_text) {
+						if reg.MatchString(just_text) && !limited(message) {
 							f(&Response{message, text, reg.FindStringSubmatch(just_text)})
 						}
 					}
@@ -49,7 +57,7 @@ func (m *Module) AddResponse(reg *regexp.Regexp, f func(*Response), permission p
 		return errors.New("Response with same regexp already exist's!")
 	}
 
-	m.handlers[name] = m.connection.AddHandler(wrap, permission)
+	m.handlers[name] = m.connection.AddScopedHandler(m.name, wrap, permission)
 	return nil
 }
 
@@ -0,0 +1,398 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/robfig/cron"
+
+	"github.com/natrim/grainbot/store"
+)
+
+// Schedule tell's when job runs next after given time, zero time ends the job
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+type once time.Time
+
+func (o once) Next(t time.Time) time.Time {
+	if t.Before(time.Time(o)) {
+		return time.Time(o)
+	}
+	return time.Time{}
+}
+
+func (o once) String() string {
+	return "once"
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return "every " + time.Duration(e).String()
+}
+
+type cronSchedule struct {
+	cron.Schedule
+	spec string
+}
+
+func (c cronSchedule) String() string {
+	return "cron " + c.spec
+}
+
+// At runs job once at time, time in past runs it right away
+func At(t time.Time) Schedule {
+	return once(t)
+}
+
+// In runs job once after duration
+func In(d time.Duration) Schedule {
+	return once(time.Now().Add(d))
+}
+
+// Every runs job repeatedly with interval
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		d = time.Second
+	}
+	return every(d)
+}
+
+// Cron runs job by standard crontab spec (eg. "30 8 * * mon-fri" or "@daily")
+func Cron(spec string) (Schedule, error) {
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.New("Invalid cron spec \"" + spec + "\"! " + err.Error())
+	}
+	return cronSchedule{Schedule: s, spec: spec}, nil
+}
+
+// Job is scheduled function of module
+type Job struct {
+	ID   int
+	Name string
+
+	module   *Module
+	schedule Schedule
+	f        func()
+	key      string //key in job store of persistent job
+
+	next    time.Time
+	stopped bool
+	stop    chan bool
+	running   sync.WaitGroup //call of f in progress
+	goroutine uint64         //id of goroutine running the job
+	lock      sync.Mutex
+}
+
+// Module return's name of module owning the job
+func (j *Job) Module() string {
+	return j.module.Name()
+}
+
+// Next return's time of next run
+func (j *Job) Next() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.next
+}
+
+// Persistent return's true if job survives restart
+func (j *Job) Persistent() bool {
+	return j.key != ""
+}
+
+func (j *Job) String() string {
+	if s, ok := j.schedule.(interface {
+		String() string
+	}); ok {
+		return s.String()
+	}
+	return "custom"
+}
+
+// Cancel stop's the job, persistent job is forgotten too
+func (j *Job) Cancel() {
+	j.halt()
+	if j.key != "" {
+		if err := jobStore().Delete(j.key); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// halt stop's the job, but keeps it persisted
+func (j *Job) halt() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.stopped {
+		return
+	}
+	j.stopped = true
+	close(j.stop)
+
+	jobs.remove(j)
+	j.module.jobLock.Lock()
+	delete(j.module.jobs, j.ID)
+	j.module.jobLock.Unlock()
+}
+
+func (j *Job) run() {
+	j.lock.Lock()
+	j.goroutine = goroutineID()
+	j.lock.Unlock()
+
+	for {
+		next := j.Next()
+		if next.IsZero() {
+			j.halt()
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-j.stop:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			//timer and stop can be ready together, stopped job never runs
+			j.lock.Lock()
+			if j.stopped {
+				j.lock.Unlock()
+				return
+			}
+			j.running.Add(1)
+			j.lock.Unlock()
+
+			j.call()
+			j.running.Done()
+
+			j.lock.Lock()
+			j.next = j.schedule.Next(now)
+			j.lock.Unlock()
+		}
+	}
+}
+
+func (j *Job) call() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Module \"%s\" job \"%s\" failure: %s", j.Module(), j.Name, r)
+		}
+	}()
+	j.f()
+}
+
+// jobList is registry of all scheduled jobs
+type jobList struct {
+	sync.Mutex
+	jobs   map[int]*Job
+	lastID int
+}
+
+var jobs = &jobList{jobs: make(map[int]*Job)}
+
+func (l *jobList) add(j *Job) {
+	l.Lock()
+	defer l.Unlock()
+	l.lastID++
+	j.ID = l.lastID
+	l.jobs[j.ID] = j
+}
+
+func (l *jobList) remove(j *Job) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.jobs, j.ID)
+}
+
+// Jobs return's pending jobs of all modules sorted by next run
+func Jobs() []*Job {
+	jobs.Lock()
+	list := make([]*Job, 0, len(jobs.jobs))
+	for _, j := range jobs.jobs {
+		list = append(list, j)
+	}
+	jobs.Unlock()
+
+	sort.Slice(list, func(i, k int) bool {
+		if ni, nk := list[i].Next(), list[k].Next(); !ni.Equal(nk) {
+			return ni.Before(nk)
+		}
+		return list[i].ID < list[k].ID
+	})
+	return list
+}
+
+// Schedule runs f by schedule until module is deactivated or job is cancelled
+func (m *Module) Schedule(name string, s Schedule, f func()) (*Job, error) {
+	return m.schedule(name, s, f, "")
+}
+
+func (m *Module) schedule(name string, s Schedule, f func(), key string) (*Job, error) {
+	if s == nil || f == nil {
+		return nil, errors.New("Nothing to schedule!")
+	}
+
+	now := time.Now()
+	next := s.Next(now)
+	if _, ok := s.(once); ok && next.IsZero() {
+		next = now //overdue one-shot runs right away
+	}
+	if next.IsZero() {
+		return nil, errors.New("Job \"" + name + "\" would never run!")
+	}
+
+	j := &Job{Name: name, module: m, schedule: s, f: f, key: key, next: next, stop: make(chan bool)}
+
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	if !m.jobsOpen {
+		return nil, errors.New("Module \"" + m.name + "\" is not active!")
+	}
+	jobs.add(j)
+	m.jobs[j.ID] = j
+
+	go j.run()
+	return j, nil
+}
+
+// persistedJob is one-shot job saved in job store
+type persistedJob struct {
+	At   time.Time `json:"at"`
+	Data []byte    `json:"data,omitempty"`
+}
+
+// jobStore keeps persistent jobs of all modules, keys are "module/job/id"
+func jobStore() *store.Store {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+	return db.Store("@jobs")
+}
+
+// HandleJob register's handler of persistent job, saved jobs of this name are scheduled again
+func (m *Module) HandleJob(name string, f func(data []byte)) error {
+	m.jobLock.Lock()
+	if !m.jobsOpen {
+		m.jobLock.Unlock()
+		return errors.New("Module \"" + m.name + "\" is not active!")
+	}
+	if _, ok := m.jobHandlers[name]; ok {
+		m.jobLock.Unlock()
+		return errors.New("Job \"" + name + "\" has handler already!")
+	}
+	m.jobHandlers[name] = f
+	m.jobLock.Unlock()
+
+	prefix := strings.ToLower(m.name) + "/" + name + "/"
+	saved := make(map[string]persistedJob)
+	err := jobStore().ForEach(prefix, func(key string, value []byte) error {
+		var p persistedJob
+		if err := json.Unmarshal(value, &p); err != nil {
+			log.Errorf("Broken job \"%s\"! %s", key, err)
+			return nil
+		}
+		saved[key] = p
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key, p := range saved {
+		if _, err := m.scheduleSaved(name, key, p, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchedulePersistent runs handler of job once at time with data, job survives restart of bot
+func (m *Module) SchedulePersistent(name string, at time.Time, data []byte) (*Job, error) {
+	m.jobLock.Lock()
+	f, ok := m.jobHandlers[name]
+	m.jobLock.Unlock()
+	if !ok {
+		return nil, errors.New("No handler of job \"" + name + "\"!")
+	}
+
+	p := persistedJob{At: at, Data: data}
+	key := strings.ToLower(m.name) + "/" + name + "/" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := jobStore().PutJSON(key, p); err != nil {
+		return nil, err
+	}
+
+	j, err := m.scheduleSaved(name, key, p, f)
+	if err != nil {
+		jobStore().Delete(key)
+	}
+	return j, err
+}
+
+func (m *Module) scheduleSaved(name, key string, p persistedJob, f func([]byte)) (*Job, error) {
+	return m.schedule(name, At(p.At), func() {
+		//forget before run, failing job is not repeated on every start
+		if err := jobStore().Delete(key); err != nil {
+			log.Error(err)
+		}
+		f(p.Data)
+	}, key)
+}
+
+// openJobs allow's scheduling, called on activation
+func (m *Module) openJobs() {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+	m.jobsOpen = true
+	m.jobs = make(map[int]*Job)
+	m.jobHandlers = make(map[string]func([]byte))
+}
+
+// closeJobs stop's all jobs of module and wait's for running ones, persistent ones stay saved
+func (m *Module) closeJobs() {
+	m.jobLock.Lock()
+	m.jobsOpen = false
+	list := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j)
+	}
+	m.jobLock.Unlock()
+
+	for _, j := range list {
+		j.halt()
+	}
+	self := goroutineID()
+	for _, j := range list {
+		j.lock.Lock()
+		inside := j.goroutine == self
+		j.lock.Unlock()
+		if !inside { //job deactivating its own module would wait for itself
+			j.running.Wait()
+		}
+	}
+}
+
+// goroutineID return's id of current goroutine (from "goroutine 42 [running]:" of stack trace)
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = bytes.TrimPrefix(buf[:runtime.Stack(buf, false)], []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > -1 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
@@ -0,0 +1,146 @@
+package modules_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/irc/irctest"
+	. "github.com/natrim/grainbot/modules"
+)
+
+func TestSchedule(t *testing.T) {
+	mod := newTestModule("pony")
+	if _, err := mod.Schedule("early", In(time.Millisecond), func() {}); err == nil {
+		t.Error("Inactive module scheduled job")
+	}
+	mod.Activate()
+
+	done := make(chan bool, 1)
+	if _, err := mod.Schedule("once", In(10*time.Millisecond), func() { done <- true }); err != nil {
+		t.Fatal(err)
+	}
+	var ticks int32
+	tick, err := mod.Schedule("tick", Every(5*time.Millisecond), func() { atomic.AddInt32(&ticks, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, finished := make(chan bool), int32(0)
+	mod.Schedule("slow", In(20*time.Millisecond), func() {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+	if tick.String() != "every 5ms" || len(Jobs()) != 3 {
+		t.Error("Wrong jobs: ", tick, Jobs())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("One-shot job did not run")
+	}
+	irctest.Eventually(t, "interval job runs", func() bool {
+		return atomic.LoadInt32(&ticks) >= 2
+	})
+
+	if _, err := Cron("61 * * * *"); err == nil {
+		t.Error("Invalid cron spec accepted")
+	}
+	if s, err := Cron("@hourly"); err != nil {
+		t.Error(err)
+	} else if next := s.Next(time.Now()); next.Minute() != 0 || next.Sub(time.Now()) > time.Hour {
+		t.Error("Wrong cron next: ", next)
+	}
+
+	<-started
+	mod.Deactivate()
+	if len(Jobs()) != 0 {
+		t.Error("Jobs not cancelled on deactivate: ", Jobs())
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("Deactivate did not wait for running job")
+	}
+	count := atomic.LoadInt32(&ticks)
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&ticks) != count {
+		t.Error("Interval job runs after deactivate")
+	}
+
+	//job deactivating its own module does not wait for itself
+	mod.Activate()
+	deactivated := make(chan bool)
+	mod.Schedule("farewell", In(time.Millisecond), func() {
+		mod.Deactivate()
+		close(deactivated)
+	})
+	select {
+	case <-deactivated:
+	case <-time.After(time.Second):
+		t.Fatal("Job deactivating its module deadlocked")
+	}
+	if mod.IsActive() || len(Jobs()) != 0 {
+		t.Error("Module not deactivated by its job")
+	}
+}
+
+func TestSchedulePersistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := OpenStore(filepath.Join(dir, "grainbot.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseStore()
+
+	got := make(chan string, 1)
+	mod := newTestModule("remind")
+	mod.Init = func(m *Module) {
+		m.HandleJob("remind", func(data []byte) { got <- string(data) })
+	}
+	mod.Activate()
+
+	if _, err := mod.SchedulePersistent("unknown", time.Now(), nil); err == nil {
+		t.Error("Job without handler scheduled")
+	}
+	job, err := mod.SchedulePersistent("remind", time.Now().Add(50*time.Millisecond), []byte("feed Angel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Persistent() {
+		t.Error("Job is not persistent")
+	}
+
+	//restart, saved job is scheduled again by its handler
+	mod.Deactivate()
+	mod.Activate()
+	if len(Jobs()) != 1 {
+		t.Fatal("Saved job not restored: ", Jobs())
+	}
+	if err := mod.HandleJob("remind", func([]byte) {}); err == nil || len(Jobs()) != 1 {
+		t.Error("Saved job restored twice: ", Jobs())
+	}
+
+	select {
+	case data := <-got:
+		if data != "feed Angel" {
+			t.Error("Wrong job data: ", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Saved job did not run")
+	}
+
+	//job ran, it is forgotten
+	mod.Deactivate()
+	mod.Activate()
+	defer mod.Deactivate()
+	time.Sleep(10 * time.Millisecond)
+	if len(Jobs()) != 0 || len(got) != 0 {
+		t.Error("Finished job restored: ", Jobs())
+	}
+}
@@ -0,0 +1,47 @@
+package modules
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/natrim/grainbot/store"
+)
+
+var (
+	db     *store.DB
+	dbLock sync.RWMutex
+)
+
+// OpenStore open's database of module stores
+func OpenStore(file string) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	if db != nil {
+		return nil //already open
+	}
+
+	d, err := store.Open(file)
+	if err != nil {
+		return err
+	}
+	db = d
+	return nil
+}
+
+// CloseStore close's database of module stores, must be called before fork so child can open it
+func CloseStore() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// Store return's persistent key-value store of module, operations fail while database is closed
+func (m *Module) Store() *store.Store {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+	return db.Store(strings.ToLower(m.name))
+}
@@ -0,0 +1,72 @@
+package system
+
+import (
+	"strings"
+
+	"github.com/natrim/grainbot/modules"
+	"github.com/natrim/grainbot/permissions"
+)
+
+// maskCommand manage's mask list (acl, ignore) by add, del and list subcommands
+type maskCommand struct {
+	name       string //command name for usage
+	list       *permissions.MaskList
+	scopes     string //usage of scope argument
+	everywhere string //description of empty scope
+
+	add     func(r *modules.Response, args []string, in func(string) string) (string, error) //add's entry, return's reply
+	removed func(mask, in string) string                                                     //reply to del
+	format  func(e permissions.MaskEntry, in func(string) string) string                     //entry in list
+	empty   string                                                                           //reply to empty list
+}
+
+// in return's description of scope for replies
+func (c *maskCommand) in(scope string) string {
+	if scope == "" {
+		return c.everywhere
+	}
+	return " in " + scope
+}
+
+func (c *maskCommand) handle(r *modules.Response) {
+	args := strings.Fields(r.Matches[4])
+
+	switch r.Matches[2] {
+	case "add":
+		reply, err := c.add(r, args, c.in)
+		if err != nil {
+			r.Mention(err.Error())
+			return
+		}
+		r.Respond("okey, " + r.Nick + "! " + reply)
+	case "del":
+		if len(args) < 1 || len(args) > 2 {
+			r.Mention("usage: " + c.name + " del <mask|$a:account> " + c.scopes)
+			return
+		}
+		scope := ""
+		if len(args) == 2 {
+			scope = args[1]
+		}
+		if err := c.list.Remove(args[0], scope); err != nil {
+			r.Mention(err.Error())
+			return
+		}
+		r.Respond("okey, " + r.Nick + "! " + c.removed(args[0], c.in(scope)))
+	case "list":
+		var entries []string
+		for _, e := range c.list.Entries() {
+			if _, scope := e.Key(); len(args) > 0 && !r.Server.Features().EqualFold(scope, args[0]) {
+				continue
+			}
+			entries = append(entries, c.format(e, c.in))
+		}
+		if len(entries) == 0 {
+			r.Respond(c.empty)
+		} else {
+			r.Respond(strings.Join(entries, ", "))
+		}
+	default:
+		r.Mention("usage: " + c.name + " add|del|list")
+	}
+}
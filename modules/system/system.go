@@ -1,12 +1,17 @@
 package system
 
 import (
+	"errors"
 	"regexp"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/ignore"
 	"github.com/natrim/grainbot/modules"
+	"github.com/natrim/grainbot/permissions"
 
 	human "github.com/dustin/go-humanize"
 	"strconv"
@@ -15,9 +20,14 @@ import (
 // precompile the command regexp
 var quitreg = regexp.MustCompile("^quit$")
 var restartreg = regexp.MustCompile("^restart$")
-var joinpartreg = regexp.MustCompile("^(join|part)( #([^ ]*))?$")
+var joinpartreg = regexp.MustCompile("^(join|part)( ([^ ]+))?$")
 var nickreg = regexp.MustCompile("^nick ([^ ]*)$")
 var statsreg = regexp.MustCompile("^stats|mem(ory)?|uptime$")
+var aclreg = regexp.MustCompile("^acl( (add|del|list))?( (.*))?$")
+var ignorereg = regexp.MustCompile("^ignore( (add|del|list))?( (.*))?$")
+var modulereg = regexp.MustCompile("^module( (list|enable|disable|reload))?( (.*))?$")
+var jobsreg = regexp.MustCompile("^jobs$")
+var reloadreg = regexp.MustCompile("^reload config$")
 
 var startTime time.Time
 
@@ -27,7 +37,7 @@ func init() {
 
 // InitSystem register's dice commands on module load
 func InitSystem(mod *modules.Module) {
-	owner := &modules.OwnerPermission{}
+	owner := permissions.RoleOwner
 	mod.AddResponse(quitreg, func(r *modules.Response) {
 		r.Respond("okey, " + r.Nick + "! Goodbye everypony!")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
@@ -37,17 +47,28 @@ func InitSystem(mod *modules.Module) {
 		syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
 	}, owner)
 	mod.AddResponse(joinpartreg, func(r *modules.Response) {
+		channel := ""
+		if len(r.Matches)-1 >= 3 && r.Matches[3] != "" {
+			channel = r.Matches[3]
+			if features := r.Server.Features(); features.ChanTypes != "" && !features.IsChannel(channel) {
+				channel = features.ChanTypes[:1] + channel
+			}
+		}
+
 		if r.Matches[1] == "join" {
-			if len(r.Matches)-1 >= 3 && r.Matches[3] != "" {
-				r.Server.Join("#" + r.Matches[3])
-				r.Respond("okey, " + r.Nick + "! let'z join " + "#" + r.Matches[3])
+			if channel != "" {
+				if err := r.Server.Join(channel); err != nil {
+					r.Mention("i can't join " + channel + "!")
+				} else {
+					r.Respond("okey, " + r.Nick + "! let'z join " + channel)
+				}
 			} else {
 				r.Mention("tell me where to join!")
 			}
 		} else if r.Matches[1] == "part" {
-			if len(r.Matches)-1 >= 3 && r.Matches[3] != "" {
-				r.Respond("okey, " + r.Nick + "! let'z leave " + "#" + r.Matches[3])
-				r.Server.Part("#" + r.Matches[3])
+			if channel != "" {
+				r.Respond("okey, " + r.Nick + "! let'z leave " + channel)
+				r.Server.Part(channel)
 			} else if r.Channel != "" {
 				r.Respond("okey, " + r.Nick + " leaving from here!")
 				r.Server.Part(r.Channel)
@@ -58,7 +79,7 @@ func InitSystem(mod *modules.Module) {
 
 	}, owner)
 	mod.AddResponse(nickreg, func(r *modules.Response) {
-		r.Server.Nick(r.Matches[1])
+		r.Server.SetNickname(r.Matches[1]) //kept on recapture and reconnect
 		r.Respond("okey, " + r.Nick + "! Let'z talk as another pony!")
 	}, owner)
 
@@ -67,4 +88,209 @@ func InitSystem(mod *modules.Module) {
 		runtime.ReadMemStats(mem)
 		r.Respond("PID: " + strconv.Itoa(syscall.Getpid()) + ", last (re)start: " + human.Time(startTime) + ", sys memory: " + human.Bytes(mem.Sys))
 	}, owner)
+
+	acl := &maskCommand{
+		name:   "acl",
+		list:   permissions.DefaultACL.MaskList,
+		scopes: "[#channel]",
+		add: func(r *modules.Response, args []string, in func(string) string) (string, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return "", errors.New("usage: acl add <mask|$a:account> <role> [#channel]")
+			}
+			role, err := permissions.ParseRole(args[1])
+			if err != nil {
+				return "", errors.New("roles are: ignored, user, trusted, op, admin, owner")
+			}
+			channel := ""
+			if len(args) == 3 {
+				channel = args[2]
+				if !r.Server.Features().IsChannel(channel) {
+					return "", errors.New(channel + " is not a channel!")
+				}
+			}
+			if err := permissions.DefaultACL.Add(args[0], role, channel); err != nil {
+				return "", err
+			}
+			return args[0] + " is " + role.String() + in(channel), nil
+		},
+		removed: func(mask, in string) string {
+			return mask + " has no role" + in + " now"
+		},
+		format: func(e permissions.MaskEntry, in func(string) string) string {
+			grant := e.(*permissions.Grant)
+			return grant.Mask + " " + grant.Role.String() + in(grant.Channel)
+		},
+		empty: "nopony has any role!",
+	}
+	mod.AddResponse(aclreg, acl.handle, owner)
+
+	ignores := &maskCommand{
+		name:       "ignore",
+		list:       ignore.DefaultList.MaskList,
+		scopes:     "[#channel|module]",
+		everywhere: " everywhere",
+		add: func(r *modules.Response, args []string, in func(string) string) (string, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return "", errors.New("usage: ignore add <mask|$a:account> [#channel|module] [duration]")
+			}
+			scope, duration := "", time.Duration(0)
+			for _, arg := range args[1:] {
+				if d, err := parseDuration(arg); err == nil {
+					duration = d
+				} else {
+					scope = arg
+				}
+			}
+			if err := ignore.DefaultList.Add(args[0], scope, duration); err != nil {
+				return "", err
+			}
+			if duration > 0 {
+				return "ignoring " + args[0] + in(scope) + " for " + duration.String(), nil
+			}
+			return "ignoring " + args[0] + in(scope), nil
+		},
+		removed: func(mask, in string) string {
+			return "listening to " + mask + in + " again"
+		},
+		format: func(e permissions.MaskEntry, in func(string) string) string {
+			entry := e.(*ignore.Entry)
+			if entry.Expires.IsZero() {
+				return entry.Mask + in(entry.Scope)
+			}
+			return entry.Mask + in(entry.Scope) + " until " + human.Time(entry.Expires)
+		},
+		empty: "i listen to everypony!",
+	}
+	mod.AddResponse(ignorereg, ignores.handle, owner)
+	mod.AddResponse(modulereg, func(r *modules.Response) {
+		manager := modules.GetManager()
+		if manager == nil {
+			r.Mention("i can't manage modules now!")
+			return
+		}
+		args := strings.Fields(r.Matches[4])
+
+		if r.Matches[2] == "list" {
+			var names []string
+			for _, name := range manager.Modules() {
+				if !manager.ModuleActive(name) {
+					name += " (disabled)"
+				} else if r.Channel != "" && modules.DisabledIn(mod.GetConfig(), r.Server.Features(), name, r.Channel) {
+					name += " (disabled here)"
+				}
+				names = append(names, name)
+			}
+			r.Respond("modules: " + strings.Join(names, ", "))
+			return
+		}
+
+		if r.Matches[2] == "" || len(args) < 1 || len(args) > 2 {
+			r.Mention("usage: module list|enable|disable|reload <name> [#channel]")
+			return
+		}
+		name, channel := args[0], ""
+		if len(args) == 2 {
+			channel = args[1]
+			if r.Matches[2] == "reload" || !r.Server.Features().IsChannel(channel) {
+				r.Mention("usage: module enable|disable <name> [#channel]")
+				return
+			}
+		}
+		if strings.EqualFold(name, mod.Name()) && r.Matches[2] == "disable" && channel == "" {
+			r.Mention("i can't disable myself!")
+			return
+		}
+
+		if channel != "" {
+			found := false
+			for _, n := range manager.Modules() {
+				found = found || strings.EqualFold(n, name)
+			}
+			if !found {
+				r.Mention("No module \"" + name + "\"!")
+				return
+			}
+			conf, features := mod.GetConfig(), r.Server.Features()
+			if conf.Modified() {
+				r.Mention(config.ErrConfigModified.Error())
+				return
+			}
+			was := modules.DisabledIn(conf, features, name, channel)
+			modules.SetDisabledIn(conf, features, name, channel, r.Matches[2] == "disable")
+			if err := conf.Save(); err != nil {
+				modules.SetDisabledIn(conf, features, name, channel, was)
+				r.Mention(err.Error())
+				return
+			}
+			r.Respond("okey, " + r.Nick + "! " + name + " is " + r.Matches[2] + "d in " + channel)
+			return
+		}
+
+		//in new goroutine, module can not wait for its own handler
+		go func() {
+			var err error
+			switch r.Matches[2] {
+			case "enable":
+				err = manager.EnableModule(name)
+			case "disable":
+				err = manager.DisableModule(name)
+			case "reload":
+				err = manager.ReloadModule(name)
+			}
+			if err != nil {
+				r.Mention(err.Error())
+				return
+			}
+			r.Respond("okey, " + r.Nick + "! " + name + " is " + strings.TrimSuffix(r.Matches[2], "e") + "ed")
+		}()
+	}, owner)
+	mod.AddResponse(jobsreg, func(r *modules.Response) {
+		jobs := modules.Jobs()
+		if len(jobs) == 0 {
+			r.Respond("no pending jobs")
+			return
+		}
+		var lines []string
+		for _, job := range jobs {
+			line := "#" + strconv.Itoa(job.ID) + " " + job.Module() + "/" + job.Name + " " + human.Time(job.Next()) + " (" + job.String()
+			if job.Persistent() {
+				line += ", saved"
+			}
+			lines = append(lines, line+")")
+		}
+		r.Respond(strings.Join(lines, "\n"))
+	}, owner)
+	mod.AddResponse(reloadreg, func(r *modules.Response) {
+		manager := modules.GetManager()
+		if manager == nil {
+			r.Mention("i can't reload config now!")
+			return
+		}
+		//in new goroutine, reload can reload this module too
+		go func() {
+			changes, err := manager.ReloadConfig()
+			if err != nil {
+				r.Mention(err.Error())
+				return
+			}
+			r.Respond("okey, " + r.Nick + "! config reloaded, changed: " + changes.String())
+		}()
+	}, owner)
+}
+
+// parseDuration parses go duration with days (eg. "1d12h")
+func parseDuration(s string) (time.Duration, error) {
+	days := time.Duration(0)
+	if i := strings.Index(s, "d"); i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		days, s = time.Duration(n)*24*time.Hour, s[i+1:]
+		if s == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	return days + d, err
 }
@@ -4,6 +4,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	update "github.com/inconshreveable/go-update"
 	"github.com/natrim/grainbot/modules"
+	"github.com/natrim/grainbot/permissions"
 	"regexp"
 	"syscall"
 )
@@ -11,7 +12,7 @@ import (
 var updatereg = regexp.MustCompile(`^update$`)
 
 func UpdateInit(mod *modules.Module) {
-	owner := &modules.OwnerPermission{}
+	owner := permissions.RoleOwner
 	mod.AddResponse(updatereg, func(r *modules.Response) {
 
 		r.Respond("okey, " + r.Nick + "!")
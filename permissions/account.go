@@ -0,0 +1,79 @@
+package permissions
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/natrim/grainbot/irc"
+)
+
+// AccountPermission allows users logged in to any of services (NickServ) accounts
+type AccountPermission struct {
+	Accounts []string
+	Timeout  time.Duration //how long to wait for WHOX reply about unknown user, 5s if zero
+}
+
+func NewAccountPermission(accounts ...string) *AccountPermission {
+	return &AccountPermission{Accounts: accounts}
+}
+
+func (p *AccountPermission) Validate(m *irc.Message) bool {
+	account := p.account(m)
+	if account == "" {
+		return false
+	}
+
+	equal := strings.EqualFold
+	if m.Server != nil {
+		equal = m.Server.Features().EqualFold
+	}
+
+	for _, a := range p.Accounts {
+		if equal(a, account) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// account find's account of sender - from account tag, tracked state or cached WHOX query as last resort
+func (p *AccountPermission) account(m *irc.Message) string {
+	if account := m.Account(); account != "" || m.Server == nil || m.Nick == "" {
+		return account
+	}
+
+	state := m.Server.State()
+	if state.User(m.Nick) != nil { //tracked user, state knows best
+		return ""
+	}
+	if account, ok := state.CachedAccount(m.Nick); ok {
+		return account
+	}
+	if _, ok := m.Server.Features().Tokens["WHOX"]; !ok {
+		return ""
+	}
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	replies, err := m.Server.Who(ctx, m.Nick)
+	if err != nil {
+		return ""
+	}
+	account := ""
+	for _, reply := range replies {
+		if m.Server.Features().EqualFold(reply.Nick, m.Nick) {
+			account = reply.Account
+			break
+		}
+	}
+
+	state.CacheAccount(m.Nick, account)
+	return account
+}
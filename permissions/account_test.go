@@ -0,0 +1,73 @@
+package permissions_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+	. "github.com/natrim/grainbot/permissions"
+)
+
+func TestAccountPermission(t *testing.T) {
+	p := NewAccountPermission("Natrim")
+
+	if !p.Validate(irc.ParseMessage("@account=natrim :Somebody!x@y PRIVMSG #pony :hi")) {
+		t.Error("Account from tag not matched")
+	}
+	if p.Validate(irc.ParseMessage("@account=luna :Natrim!x@y PRIVMSG #pony :hi")) {
+		t.Error("Other account allowed")
+	}
+	if p.Validate(irc.ParseMessage(":Natrim!x@y PRIVMSG #pony :hi")) {
+		t.Error("Nick without account allowed")
+	}
+}
+
+func TestAccountLookupCache(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	server.Send(":irc.server 005 dashy WHOX :are supported")
+	irctest.Eventually(t, "WHOX supported", func() bool {
+		_, ok := conn.Features().Tokens["WHOX"]
+		return ok
+	})
+
+	p := &AccountPermission{Accounts: []string{"Natrim"}, Timeout: time.Second}
+	validate := func(nick, account string) bool {
+		m := irc.ParseMessage(":" + nick + "!x@y PRIVMSG dashy :hi")
+		m.Server = conn
+
+		result := make(chan bool)
+		go func() { result <- p.Validate(m) }()
+		if line, ok := server.Wait(100*time.Millisecond, "WHO "); ok {
+			if account == "" {
+				t.Errorf("%s looked up again: %q", nick, line)
+			}
+			server.Send(":irc.server 354 dashy 616 * x y irc.server "+nick+" H 0 "+account+" :Somepony",
+				":irc.server 315 dashy "+nick+" :End of /WHO list.")
+		} else if account != "" {
+			t.Errorf("%s not looked up", nick)
+		}
+		return <-result
+	}
+
+	if !validate("Natrim", "natrim") || !validate("NATRIM", "") {
+		t.Error("Looked up account not allowed")
+	}
+	if validate("Luna", "0") || validate("luna", "") {
+		t.Error("User without account allowed")
+	}
+
+	//cache is forgotten when user changes
+	server.Send(":Natrim!x@y ACCOUNT *", ":Luna!x@y NICK Natrim")
+	time.Sleep(20 * time.Millisecond)
+	if validate("Natrim", "0") || validate("Natrim", "") {
+		t.Error("Account cached after change")
+	}
+	server.Send(":Natrim!x@y QUIT :bye")
+	time.Sleep(20 * time.Millisecond)
+	if !validate("Natrim", "natrim") {
+		t.Error("Account cached after quit")
+	}
+}
@@ -0,0 +1,111 @@
+package permissions
+
+import (
+	"strings"
+
+	"github.com/natrim/grainbot/irc"
+)
+
+// HostmaskPermission allows users matching any of nick!user@host glob masks
+type HostmaskPermission struct {
+	Masks []string
+	Fold  func(string) string //irc case mapping, of message server (or RFC 1459) if nil
+}
+
+func NewHostmaskPermission(masks ...string) *HostmaskPermission {
+	return &HostmaskPermission{Masks: masks}
+}
+
+func (p *HostmaskPermission) Validate(m *irc.Message) bool {
+	fold := p.Fold
+	if fold == nil {
+		fold = FoldOf(m)
+	}
+	return p.match(fold, m.Nick, m.User, m.Host)
+}
+
+// Match return's true if nick!user@host matches any of masks
+func (p *HostmaskPermission) Match(nick, user, host string) bool {
+	fold := p.Fold
+	if fold == nil {
+		fold = irc.FoldRFC1459
+	}
+	return p.match(fold, nick, user, host)
+}
+
+func (p *HostmaskPermission) match(fold func(string) string, nick, user, host string) bool {
+	if nick == "" {
+		return false
+	}
+
+	hostmask := fold(nick + "!" + user + "@" + host)
+	for _, mask := range p.Masks {
+		if MatchMask(fold(NormalizeMask(mask)), hostmask) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchUser return's true if sender of message matches mask - nick!user@host glob or $a:account
+func MatchUser(mask string, m *irc.Message) bool {
+	fold := FoldOf(m)
+
+	if strings.HasPrefix(mask, "$a:") {
+		account := m.Account()
+		return account != "" && fold(mask[3:]) == fold(account)
+	}
+
+	return m.Nick != "" && MatchMask(fold(NormalizeMask(mask)), fold(m.Nick+"!"+m.User+"@"+m.Host))
+}
+
+// FoldOf return's case mapping of server message came from, RFC 1459 if unknown
+func FoldOf(m *irc.Message) func(string) string {
+	if m.Server != nil {
+		return m.Server.Features().Fold
+	}
+	return irc.FoldRFC1459
+}
+
+// NormalizeMask completes partial mask, "nick" becomes "nick!*@*" and "user@host" becomes "*!user@host"
+func NormalizeMask(mask string) string {
+	if strings.Contains(mask, "!") {
+		if !strings.Contains(mask, "@") {
+			return mask + "@*"
+		}
+		return mask
+	}
+	if strings.Contains(mask, "@") {
+		return "*!" + mask
+	}
+	return mask + "!*@*"
+}
+
+// MatchMask matches glob mask with * and ? against text
+func MatchMask(mask, text string) bool {
+	m, t := 0, 0
+	star, mark := -1, 0
+
+	for t < len(text) {
+		if m < len(mask) && (mask[m] == '?' || mask[m] == text[t]) {
+			m++
+			t++
+		} else if m < len(mask) && mask[m] == '*' {
+			star, mark = m, t
+			m++
+		} else if star > -1 {
+			m = star + 1
+			mark++
+			t = mark
+		} else {
+			return false
+		}
+	}
+
+	for m < len(mask) && mask[m] == '*' {
+		m++
+	}
+
+	return m == len(mask)
+}
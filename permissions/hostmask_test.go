@@ -0,0 +1,68 @@
+package permissions_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+	. "github.com/natrim/grainbot/permissions"
+)
+
+func TestMatchMask(t *testing.T) {
+	cases := []struct {
+		mask, text string
+		match      bool
+	}{
+		{"*!*@*", "dash!rd@cloud.sdale", true},
+		{"dash!*@*.sdale", "dash!rd@cloud.sdale", true},
+		{"dash!*@*.sdale", "dash!rd@cloud.sdale.evil", false},
+		{"d?sh!*@*", "dash!rd@cloud", true},
+		{"d?sh!*@*", "dsh!rd@cloud", false},
+		{"*dash*", "rainbowdash!x@y", true},
+		{"", "", true},
+	}
+
+	for _, c := range cases {
+		if MatchMask(c.mask, c.text) != c.match {
+			t.Errorf("MatchMask(%q, %q) != %v", c.mask, c.text, c.match)
+		}
+	}
+}
+
+func TestHostmaskPermission(t *testing.T) {
+	p := NewHostmaskPermission("Natrim!*@natrim.cz", "*!admin@*.Equestria")
+
+	if !p.Match("natrim", "whatever", "NATRIM.cz") {
+		t.Error("Owner mask not matched case insensitively")
+	}
+	if p.Match("Natrim", "whatever", "evil.host") {
+		t.Error("Owner nick from other host allowed!")
+	}
+	if !p.Validate(&irc.Message{Nick: "Luna", User: "admin", Host: "moon.equestria"}) {
+		t.Error("User mask not matched")
+	}
+	nick := NewHostmaskPermission("Dash[1]")
+	if !nick.Match("dash{1}", "x", "y") {
+		t.Error("RFC 1459 case mapping not used")
+	}
+
+	//case mapping of server by default
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+	server.Send(":irc.server 005 dashy CASEMAPPING=ascii :are supported by this server")
+	irctest.Eventually(t, "features parsed", func() bool { return conn.Features().CaseMapping == "ascii" })
+	dash := &irc.Message{Nick: "dash{1}", User: "x", Host: "y", Server: conn}
+	if nick.Validate(dash) || MatchUser("Dash[1]", dash) {
+		t.Error("Server case mapping not used")
+	}
+	dash.Nick = "DASH[1]"
+	if !nick.Validate(dash) || !MatchUser("Dash[1]", dash) {
+		t.Error("Server case mapping not used")
+	}
+
+	nick.Fold = strings.ToLower
+	if nick.Match("dash{1}", "x", "y") {
+		t.Error("Custom case mapping not used")
+	}
+}
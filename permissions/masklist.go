@@ -0,0 +1,185 @@
+package permissions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/irc"
+)
+
+// MaskEntry is entry of MaskList - user mask (see MatchUser) in scope (channel or module, empty for everywhere)
+type MaskEntry interface {
+	Key() (mask, scope string)
+	Expired(now time.Time) bool
+}
+
+// MaskList is persistent list of mask entries, at most one entry per mask and scope - base of ACL and ignore list
+type MaskList struct {
+	name  string                    //for errors, eg. "ACL"
+	entry func() MaskEntry          //new empty entry for loading
+	less  func(a, b MaskEntry) bool //order of Entries, nil keeps order of adding
+
+	Fold func(string) string //irc case mapping of masks and scopes, RFC 1459 if nil
+
+	file    string
+	entries []MaskEntry
+	lock    sync.RWMutex
+}
+
+func NewMaskList(name string, entry func() MaskEntry, less func(a, b MaskEntry) bool) *MaskList {
+	return &MaskList{name: name, entry: entry, less: less}
+}
+
+// NormalizeUserMask completes partial hostmask (see NormalizeMask), account masks ($a:account) stay
+func NormalizeUserMask(mask string) string {
+	if strings.HasPrefix(mask, "$a:") {
+		return mask
+	}
+	return NormalizeMask(mask)
+}
+
+// Load read's entries from json file, missing file is empty list
+func (l *MaskList) Load(file string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.file = file
+	l.entries = nil
+
+	buff, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Cannot load " + l.name + " file! " + err.Error())
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(buff, &raw); err != nil {
+		return errors.New("Cannot load " + l.name + " file! " + err.Error())
+	}
+	for _, r := range raw {
+		e := l.entry()
+		if err := json.Unmarshal(r, e); err != nil {
+			l.entries = nil
+			return errors.New("Cannot load " + l.name + " file! " + err.Error())
+		}
+		l.entries = append(l.entries, e)
+	}
+
+	return nil
+}
+
+// Save write's not expired entries atomically to file they were loaded from
+func (l *MaskList) Save() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.save()
+}
+
+// save is Save called with lock held
+func (l *MaskList) save() error {
+	now := time.Now()
+	entries := l.entries[:0]
+	for _, e := range l.entries {
+		if !e.Expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	l.entries = entries
+
+	if l.file == "" {
+		return nil //in memory only
+	}
+
+	buff, err := json.MarshalIndent(l.entries, "", "    ")
+	if err == nil {
+		err = config.WriteFile(l.file, buff, 0600, 0)
+	}
+	if err != nil {
+		return errors.New("Cannot save " + l.name + " file! " + err.Error())
+	}
+
+	return nil
+}
+
+// Put add's entry, replacing entry of the same mask in the same scope, and saves list
+func (l *MaskList) Put(e MaskEntry) error {
+	mask, scope := e.Key()
+	if mask == "" {
+		return errors.New("Empty mask!")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if i := l.find(mask, scope); i > -1 {
+		l.entries[i] = e
+	} else {
+		l.entries = append(l.entries, e)
+	}
+	return l.save()
+}
+
+// Remove delete's entry of mask in scope and saves list
+func (l *MaskList) Remove(mask, scope string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	i := l.find(NormalizeUserMask(mask), scope)
+	if i < 0 {
+		return errors.New("No such mask in " + l.name + "!")
+	}
+	l.entries = append(l.entries[:i], l.entries[i+1:]...)
+	return l.save()
+}
+
+// find return's index of entry, called with lock held
+func (l *MaskList) find(mask, scope string) int {
+	fold := l.Fold
+	if fold == nil {
+		fold = irc.FoldRFC1459
+	}
+	mask, scope = fold(mask), fold(scope)
+	for i, e := range l.entries {
+		if m, s := e.Key(); fold(m) == mask && fold(s) == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// Each call's f for every not expired entry, list is locked meanwhile
+func (l *MaskList) Each(f func(MaskEntry)) {
+	now := time.Now()
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for _, e := range l.entries {
+		if !e.Expired(now) {
+			f(e)
+		}
+	}
+}
+
+// Entries return's not expired entries in order of list
+func (l *MaskList) Entries() []MaskEntry {
+	var entries []MaskEntry
+	l.Each(func(e MaskEntry) {
+		entries = append(entries, e)
+	})
+
+	if l.less != nil {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return l.less(entries[i], entries[j])
+		})
+	}
+	return entries
+}
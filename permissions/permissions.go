@@ -1,19 +1,24 @@
 package permissions
 
-import "errors"
+import (
+	"errors"
 
+	"github.com/natrim/grainbot/irc"
+)
+
+// Permission decides if sender of message is allowed to trigger handler
 type Permission interface {
-	Validate(nick, user, host string) bool
+	Validate(m *irc.Message) bool
 }
 
 type Allow struct{}
 type Deny struct{}
 
-func (p *Allow) Validate(nick, user, host string) bool {
+func (p *Allow) Validate(m *irc.Message) bool {
 	return true
 }
 
-func (p *Deny) Validate(nick, user, host string) bool {
+func (p *Deny) Validate(m *irc.Message) bool {
 	return false
 }
 
@@ -52,9 +57,9 @@ func RemovePermission(name string) error {
 	return nil
 }
 
-func CheckPermission(name, nick, user, host string) bool {
+func CheckPermission(name string, m *irc.Message) bool {
 	if p, ok := permissionList[name]; ok {
-		return p.Validate(nick, user, host)
+		return p.Validate(m)
 	}
 
 	return false
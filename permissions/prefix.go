@@ -0,0 +1,28 @@
+package permissions
+
+import "github.com/natrim/grainbot/irc"
+
+// PrefixPermission allows users holding channel prefix (eg. '@') or any higher one in channel of the message
+type PrefixPermission struct {
+	Prefix byte
+}
+
+var (
+	ChannelOwner  = &PrefixPermission{'~'}
+	ChannelAdmin  = &PrefixPermission{'&'}
+	ChannelOp     = &PrefixPermission{'@'}
+	ChannelHalfOp = &PrefixPermission{'%'}
+	ChannelVoice  = &PrefixPermission{'+'}
+)
+
+func NewPrefixPermission(prefix byte) *PrefixPermission {
+	return &PrefixPermission{Prefix: prefix}
+}
+
+// Validate checks live channel state, prefix not supported by server is never granted
+func (p *PrefixPermission) Validate(m *irc.Message) bool {
+	if m.Server == nil || m.Channel == "" || m.Nick == "" {
+		return false
+	}
+	return m.Server.State().HasPrefix(m.Channel, m.Nick, p.Prefix)
+}
@@ -0,0 +1,50 @@
+package permissions_test
+
+import (
+	"testing"
+
+	"github.com/natrim/grainbot/irc"
+	"github.com/natrim/grainbot/irc/irctest"
+	. "github.com/natrim/grainbot/permissions"
+)
+
+func TestPrefixPermission(t *testing.T) {
+	conn, server := irctest.NewServer(t)
+	defer conn.Disconnect()
+
+	server.Send(":irc.server 005 dashy PREFIX=(qaohv)~&@%+ :are supported",
+		":dashy!grainbot@bot.host JOIN #pony",
+		":irc.server 353 dashy = #pony :dashy &Celestia %Twilight +Spike Derpy",
+		":irc.server 366 dashy #pony :End of /NAMES list.")
+
+	message := func(nick string) *irc.Message {
+		m := irc.ParseMessage(":" + nick + "!x@y PRIVMSG #pony :hi")
+		m.Server, m.Channel = conn, "#pony"
+		return m
+	}
+
+	irctest.Eventually(t, "names are tracked", func() bool {
+		return len(conn.State().Users("#pony")) == 5
+	})
+
+	if !ChannelOp.Validate(message("Celestia")) || !ChannelHalfOp.Validate(message("twilight")) {
+		t.Error("Higher prefix not allowed")
+	}
+	if ChannelOp.Validate(message("Twilight")) || ChannelVoice.Validate(message("Derpy")) || !ChannelVoice.Validate(message("Spike")) {
+		t.Error("Lower prefix allowed")
+	}
+
+	server.Send(":Celestia!x@y MODE #pony -a+v Celestia Derpy")
+	irctest.Eventually(t, "mode change is tracked", func() bool {
+		return ChannelVoice.Validate(message("Derpy"))
+	})
+	if ChannelOp.Validate(message("Celestia")) {
+		t.Error("Mode change not used")
+	}
+
+	pm := message("Celestia")
+	pm.Channel = ""
+	if ChannelVoice.Validate(pm) {
+		t.Error("Private message allowed")
+	}
+}
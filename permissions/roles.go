@@ -0,0 +1,144 @@
+package permissions
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/natrim/grainbot/irc"
+)
+
+// Role is level of trust, higher role has all rights of lower ones
+type Role int
+
+const (
+	RoleIgnored Role = iota
+	RoleUser
+	RoleTrusted
+	RoleOp
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = []string{"ignored", "user", "trusted", "op", "admin", "owner"}
+
+func init() {
+	//roles are available as named permissions too
+	for i, name := range roleNames {
+		AddPermission(name, Role(i))
+	}
+}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return "unknown"
+	}
+	return roleNames[r]
+}
+
+// ParseRole return's role by its name
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if strings.EqualFold(n, name) {
+			return Role(i), nil
+		}
+	}
+	return RoleIgnored, errors.New("Unknown role \"" + name + "\"!")
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// Validate allows users with at least this role in DefaultACL
+func (r Role) Validate(m *irc.Message) bool {
+	return DefaultACL.RoleOf(m) >= r
+}
+
+// Grant gives role to users matching mask (see MatchUser), optionally only in channel
+type Grant struct {
+	Mask    string `json:"mask"`
+	Role    Role   `json:"role"`
+	Channel string `json:"channel,omitempty"`
+}
+
+func (g *Grant) Key() (string, string) {
+	return g.Mask, g.Channel
+}
+
+// Expired is always false, grants are for ever
+func (g *Grant) Expired(now time.Time) bool {
+	return false
+}
+
+// ACL is persistent list of role grants
+type ACL struct {
+	Owner Permission //users allowed by it are owners, even without grant
+
+	*MaskList
+}
+
+// DefaultACL is used by roles as permissions
+var DefaultACL = NewACL()
+
+func NewACL() *ACL {
+	return &ACL{MaskList: NewMaskList("ACL", func() MaskEntry { return &Grant{} }, func(a, b MaskEntry) bool {
+		x, y := a.(*Grant), b.(*Grant)
+		if x.Channel != y.Channel {
+			return x.Channel < y.Channel
+		}
+		return x.Role > y.Role
+	})}
+}
+
+// Add grant's role to mask, replacing previous grant of the mask in same channel
+func (a *ACL) Add(mask string, role Role, channel string) error {
+	if mask == "" {
+		return errors.New("Empty mask!")
+	}
+	return a.Put(&Grant{Mask: NormalizeUserMask(mask), Role: role, Channel: channel})
+}
+
+// List return's grants sorted by channel and role
+func (a *ACL) List() []Grant {
+	var grants []Grant
+	for _, e := range a.Entries() {
+		grants = append(grants, *e.(*Grant))
+	}
+	return grants
+}
+
+// RoleOf return's highest role of message sender in its channel, user if nothing is granted
+func (a *ACL) RoleOf(m *irc.Message) Role {
+	if a.Owner != nil && a.Owner.Validate(m) {
+		return RoleOwner
+	}
+
+	fold := FoldOf(m)
+
+	role, granted := RoleUser, false
+	a.Each(func(e MaskEntry) {
+		grant := e.(*Grant)
+		if grant.Channel != "" && fold(grant.Channel) != fold(m.Channel) {
+			return
+		}
+		if !MatchUser(grant.Mask, m) {
+			return
+		}
+
+		if !granted || grant.Role > role {
+			role, granted = grant.Role, true
+		}
+	})
+
+	return role
+}
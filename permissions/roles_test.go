@@ -0,0 +1,86 @@
+package permissions_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/natrim/grainbot/irc"
+	. "github.com/natrim/grainbot/permissions"
+)
+
+func TestACL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "acl.json")
+
+	acl := NewACL()
+	if err := acl.Load(file); err != nil {
+		t.Fatal("Missing ACL file is not empty ACL: ", err)
+	}
+	acl.Add("Twilight", RoleAdmin, "")
+	acl.Add("*!*@spammer.net", RoleIgnored, "")
+	acl.Add("$a:spike", RoleOp, "#Pony")
+
+	twilight := irc.ParseMessage(":twilight!twi@library PRIVMSG #pony :hi")
+	spammer := irc.ParseMessage(":Derpy!derp@spammer.net PRIVMSG #pony :muffins")
+	spike := irc.ParseMessage("@account=Spike :Spike!spike@library PRIVMSG #pony :gems")
+	spike.Channel = "#pony"
+
+	if acl.RoleOf(twilight) != RoleAdmin || acl.RoleOf(spammer) != RoleIgnored || acl.RoleOf(spike) != RoleOp {
+		t.Error("Wrong roles: ", acl.RoleOf(twilight), acl.RoleOf(spammer), acl.RoleOf(spike))
+	}
+	spike.Channel = "#canterlot"
+	if acl.RoleOf(spike) != RoleUser {
+		t.Error("Channel grant used in other channel")
+	}
+
+	loaded := NewACL()
+	if err := loaded.Load(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.List()) != 3 || loaded.List()[0].Mask != "Twilight!*@*" {
+		t.Error("ACL not persisted: ", loaded.List())
+	}
+
+	if err := loaded.Remove("twilight", ""); err != nil || loaded.RoleOf(twilight) != RoleUser {
+		t.Error("Grant not removed: ", err)
+	}
+	if err := loaded.Remove("twilight", ""); err == nil {
+		t.Error("Removed not existing grant")
+	}
+
+	//masks are compared by irc case mapping
+	loaded.Add("[Derpy]", RoleOp, "#Pony")
+	loaded.Add("{derpy}", RoleAdmin, "#pony")
+	if grants := loaded.List(); len(grants) != 3 || grants[2].Mask != "{derpy}!*@*" || grants[2].Role != RoleAdmin {
+		t.Error("Same mask in irc case mapping granted twice: ", grants)
+	}
+	if err := loaded.Remove("[DERPY]", "#PONY"); err != nil || len(loaded.List()) != 2 {
+		t.Error("Grant not removed by irc case mapping: ", err)
+	}
+	loaded.Fold = strings.ToLower //ascii
+	loaded.Add("[Derpy]", RoleOp, "")
+	loaded.Add("{derpy}", RoleAdmin, "")
+	if len(loaded.List()) != 4 {
+		t.Error("Different masks in ascii case mapping merged: ", loaded.List())
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole("Admin"); err != nil || role != RoleAdmin || role.String() != "admin" {
+		t.Error("Role not parsed")
+	}
+	if _, err := ParseRole("alicorn"); err == nil {
+		t.Error("Unknown role parsed")
+	}
+	stranger := irc.ParseMessage(":x!y@z PRIVMSG #a :b")
+	if RoleOwner.Validate(stranger) || !RoleUser.Validate(stranger) {
+		t.Error("Unknown user is owner or is not user")
+	}
+}
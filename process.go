@@ -18,7 +18,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-// SignalChan accepts SIGINT, SIGTERM, SIGQUIT resp. SIGUSR2 signals for quit resp. restart
+// SignalChan accepts SIGINT, SIGTERM, SIGQUIT resp. SIGUSR2 resp. SIGHUP signals for quit resp. restart resp. config reload
 var SignalChan chan os.Signal
 
 // Just alias some syscall signals
@@ -27,6 +27,7 @@ const (
 	SIGTERM = syscall.SIGTERM
 	SIGQUIT = syscall.SIGQUIT
 	SIGUSR2 = syscall.SIGUSR2
+	SIGHUP  = syscall.SIGHUP
 )
 
 // Getpid return's current process pid
@@ -86,7 +87,7 @@ func findSocket() (l net.Conn, err error) {
 	return
 }
 
-// WaitOnSignals will block process until receives quit or restart signal
+// WaitOnSignals will block process until receives quit or restart signal, reloads config on hangup
 func (bot *Bot) WaitOnSignals(l net.Conn) error {
 	SignalChan = make(chan os.Signal, 2)
 	signal.Notify(
@@ -95,6 +96,7 @@ func (bot *Bot) WaitOnSignals(l net.Conn) error {
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 		syscall.SIGUSR2,
+		syscall.SIGHUP,
 	)
 	forked := false
 	for {
@@ -106,6 +108,10 @@ func (bot *Bot) WaitOnSignals(l net.Conn) error {
 			return nil //just unblock
 		case syscall.SIGQUIT:
 			return nil //just unblock
+		case syscall.SIGHUP:
+			if _, err := bot.ReloadConfig(); err != nil {
+				log.Errorln("Config reload failed:", err)
+			}
 		case syscall.SIGUSR2:
 			if forked { //druhy a dalsi jen ukonci
 				return nil
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/modules"
+)
+
+// how often is config file checked for changes when WatchConfig is on
+const configWatchInterval = 5 * time.Second
+
+// ReloadConfig load's config file again, applies changes and notifies modules
+func (b *Bot) ReloadConfig() (config.Changes, error) {
+	//one reload is applied and notified before other starts
+	b.reloadLock.Lock()
+	defer b.reloadLock.Unlock()
+
+	changes, err := b.Config.Reload()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Config reloaded, changed: %s.", changes)
+
+	if len(changes) > 0 {
+		b.applyConfig(changes)
+		modules.Reload(b.Connection, b.Config, changes)
+		if changes.Has("DisabledModules") {
+			b.applyDisabledModules()
+		}
+	}
+
+	b.runHook(modules.HookConfigReload)
+
+	if len(changes) > 0 {
+		b.mlock.Lock()
+		order := make([]*modules.Module, len(b.order))
+		copy(order, b.order)
+		b.mlock.Unlock()
+
+		for _, mod := range order {
+			mod.ConfigChanged(changes)
+		}
+	}
+
+	return changes, nil
+}
+
+// applyConfig update's connection by changed config
+func (b *Bot) applyConfig(changes config.Changes) {
+	b.Config.RLock()
+	defer b.Config.RUnlock()
+
+	if changes.Has("MaxLines") {
+		b.Connection.MaxLines = b.Config.MaxLines
+	}
+	if changes.Has("Nick") && b.Config.Nick != "" {
+		b.Connection.SetNickname(b.Config.Nick)
+	}
+
+	var restart []string
+	for _, key := range []string{"HostName", "Port", "SSL", "TLSCert", "TLSKey", "TLSCA", "TLSFingerprint", "TLSServerName", "TLSInsecure", "TLSMinVersion", "UserName", "RealName", "SASLMechanism", "SASLUser", "SASLPassword", "SASLAbort"} {
+		if changes.Has(key) {
+			restart = append(restart, key)
+		}
+	}
+	if len(restart) > 0 {
+		log.Warnf("Change of %s needs restart!", strings.Join(restart, ", "))
+	}
+}
+
+// applyDisabledModules activate's and deactivate's modules by disabled modules in config
+func (b *Bot) applyDisabledModules() {
+	b.mlock.Lock()
+	defer b.mlock.Unlock()
+
+	//module is off if disabled or if it needs module which is off
+	off := make(map[*modules.Module]bool)
+	for _, mod := range b.order {
+		off[mod] = moduleDisabled(b.Config, mod.Name())
+		for _, other := range b.order {
+			if off[other] && dependsOn(mod, other) {
+				off[mod] = true
+			}
+		}
+	}
+
+	for i := len(b.order) - 1; i >= 0; i-- {
+		if off[b.order[i]] {
+			b.deactivateModule(b.order[i])
+		}
+	}
+	for _, mod := range b.order {
+		if !off[mod] && !mod.IsActive() && b.checkDependencies(mod) == nil {
+			b.activateModule(mod)
+		}
+	}
+}
+
+// watchConfig reload's config when its file changes and WatchConfig is on
+func (b *Bot) watchConfig() {
+	lastErr := ""
+	for range time.Tick(configWatchInterval) {
+		if b.restarting {
+			return
+		}
+
+		b.Config.RLock()
+		watch := b.Config.WatchConfig
+		b.Config.RUnlock()
+
+		if watch && b.Config.Modified() {
+			if _, err := b.ReloadConfig(); err != nil {
+				if err.Error() != lastErr { //broken file is reported once
+					log.Error(err)
+				}
+				lastErr = err.Error()
+			} else {
+				lastErr = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/natrim/grainbot/config"
+	"github.com/natrim/grainbot/modules"
+	"github.com/natrim/grainbot/modules/autojoin"
+)
+
+// writeConfig replaces config file of bot
+func writeConfig(t *testing.T, b *Bot, content string) {
+	if err := ioutil.WriteFile(filepath.Join(b.Config.Dir(), "config.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	var notified []config.Changes
+	var running, overlapped int32
+	inside := make(chan bool, 2)
+	pony := modules.NewModule("pony", nil, nil)
+	pony.OnConfigChange = func(m *modules.Module, changes config.Changes) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		notified = append(notified, changes)
+		inside <- true
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+	derpy := modules.NewModule("derpy", nil, nil)
+
+	b, server, stop := startBot(t, `{"HostName": "irc.test", "Nick": "dashy"}`, pony, derpy)
+	defer stop()
+
+	//second reload waits until first one is notified
+	writeConfig(t, b, `{"HostName": "irc.test", "Nick": "Spike", "MaxLines": 3, "Modules": {"pony": {"color": "pink"}}}`)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := b.ReloadConfig(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-inside
+	writeConfig(t, b, `{"HostName": "irc.test", "Nick": "Spike", "MaxLines": 3, "DisabledModules": ["derpy"], "Modules": {"pony": {"color": "pink"}}}`)
+	go func() {
+		defer wg.Done()
+		if _, err := b.ReloadConfig(); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("Reloads are not serialized")
+	}
+	if len(notified) != 2 || notified[0].String() != "MaxLines, Nick, pony.color" || notified[1].String() != "DisabledModules" {
+		t.Error("Wrong changes notified: ", notified)
+	}
+
+	server.Expect(t, "NICK Spike")
+	if b.Connection.MaxLines != 3 {
+		t.Error("Config not applied to connection")
+	}
+	if b.ModuleActive("derpy") || !b.ModuleActive("pony") {
+		t.Error("Disabled modules not applied")
+	}
+
+	writeConfig(t, b, `{"HostName": "irc.test", "Nick": "Spike", "MaxLines": 3, "Modules": {"pony": {"color": "pink"}}}`)
+	b.ReloadConfig()
+	<-inside
+	if !b.ModuleActive("derpy") {
+		t.Error("Enabled module not activated")
+	}
+
+	writeConfig(t, b, `{"Port": 6667}`)
+	if _, err := b.ReloadConfig(); err == nil || b.Config.Nick != "Spike" {
+		t.Error("Invalid config applied: ", err)
+	}
+}
+
+func TestReloadAutojoin(t *testing.T) {
+	aj := modules.NewModule("autojoin", autojoin.Init, nil)
+	b, server, stop := startBot(t, `{"HostName": "irc.test", "Modules": {"autojoin": {"channels": ["#pony", "#Cloudsdale"]}}}`, aj)
+	defer stop()
+
+	writeConfig(t, b, `{"HostName": "irc.test", "Modules": {"autojoin": {"channels": ["#PONY", "#canterlot"]}}}`)
+	if _, err := b.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if line, _ := server.Wait(time.Second, "JOIN", "PART"); line != "JOIN #canterlot" {
+		t.Errorf("Wrong join: %q", line)
+	}
+	if line, _ := server.Wait(time.Second, "JOIN", "PART"); line != "PART #Cloudsdale" {
+		t.Errorf("Wrong part: %q", line)
+	}
+	if line, ok := server.Wait(100*time.Millisecond, "JOIN", "PART"); ok {
+		t.Errorf("Channel joined again: %q", line)
+	}
+}
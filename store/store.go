@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotOpen is returned when database is not open (before start or during restart)
+var ErrNotOpen = errors.New("Store is not open!")
+
+// DB is embedded on-disk database, every write is fsynced before commit returns
+type DB struct {
+	bolt *bolt.DB
+}
+
+// Open open's or create's database file, waits while other process (eg. restarting parent) holds it
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.New("Cannot open store! " + err.Error())
+	}
+	return &DB{bolt: db}, nil
+}
+
+// Close close's database file
+func (d *DB) Close() error {
+	if d == nil || d.bolt == nil {
+		return nil
+	}
+	return d.bolt.Close()
+}
+
+// Store return's store in namespace, store of nil database is never open
+func (d *DB) Store(namespace string) *Store {
+	return &Store{db: d, bucket: []byte(namespace)}
+}
+
+// Store is namespaced key-value store
+type Store struct {
+	db     *DB
+	bucket []byte
+}
+
+// Tx is transaction of store
+type Tx struct {
+	b *bolt.Bucket //nil in read only transaction of empty store
+}
+
+// Get return's copy of value, nil if key does not exist
+func (tx *Tx) Get(key string) []byte {
+	if tx.b == nil {
+		return nil
+	}
+	value := tx.b.Get([]byte(key))
+	if value == nil {
+		return nil
+	}
+	copied := make([]byte, len(value))
+	copy(copied, value)
+	return copied
+}
+
+// Put set's value of key
+func (tx *Tx) Put(key string, value []byte) error {
+	if tx.b == nil {
+		return errors.New("Read only transaction!")
+	}
+	return tx.b.Put([]byte(key), value)
+}
+
+// Delete remove's key
+func (tx *Tx) Delete(key string) error {
+	if tx.b == nil {
+		return errors.New("Read only transaction!")
+	}
+	return tx.b.Delete([]byte(key))
+}
+
+// ForEach call's f for keys starting with prefix in key order, value is valid only during call
+func (tx *Tx) ForEach(prefix string, f func(key string, value []byte) error) error {
+	if tx.b == nil {
+		return nil
+	}
+	c := tx.b.Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+		if err := f(string(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetJSON decodes json value of key into v, return's false if key does not exist
+func (tx *Tx) GetJSON(key string, v interface{}) (bool, error) {
+	value := tx.Get(key)
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// PutJSON set's value of key to v encoded as json
+func (tx *Tx) PutJSON(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Put(key, value)
+}
+
+// Update run's f in read-write transaction, it is rolled back if f return's error
+func (s *Store) Update(f func(tx *Tx) error) error {
+	if s.db == nil || s.db.bolt == nil {
+		return ErrNotOpen
+	}
+	return notOpen(s.db.bolt.Update(func(btx *bolt.Tx) error {
+		b, err := btx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		return f(&Tx{b: b})
+	}))
+}
+
+// View run's f in read only transaction
+func (s *Store) View(f func(tx *Tx) error) error {
+	if s.db == nil || s.db.bolt == nil {
+		return ErrNotOpen
+	}
+	return notOpen(s.db.bolt.View(func(btx *bolt.Tx) error {
+		return f(&Tx{b: btx.Bucket(s.bucket)})
+	}))
+}
+
+func notOpen(err error) error {
+	if err == bolt.ErrDatabaseNotOpen {
+		return ErrNotOpen
+	}
+	return err
+}
+
+// Get return's value of key, nil if key does not exist
+func (s *Store) Get(key string) ([]byte, error) {
+	var value []byte
+	err := s.View(func(tx *Tx) error {
+		value = tx.Get(key)
+		return nil
+	})
+	return value, err
+}
+
+// Put set's value of key
+func (s *Store) Put(key string, value []byte) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.Put(key, value)
+	})
+}
+
+// Delete remove's key
+func (s *Store) Delete(key string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+// ForEach call's f for keys starting with prefix
+func (s *Store) ForEach(prefix string, f func(key string, value []byte) error) error {
+	return s.View(func(tx *Tx) error {
+		return tx.ForEach(prefix, f)
+	})
+}
+
+// GetJSON decodes json value of key into v, return's false if key does not exist
+func (s *Store) GetJSON(key string, v interface{}) (bool, error) {
+	var found bool
+	err := s.View(func(tx *Tx) (err error) {
+		found, err = tx.GetJSON(key, v)
+		return
+	})
+	return found, err
+}
+
+// PutJSON set's value of key to v encoded as json
+func (s *Store) PutJSON(key string, v interface{}) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.PutJSON(key, v)
+	})
+}
@@ -0,0 +1,87 @@
+package store_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/natrim/grainbot/store"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grainbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "grainbot.db")
+
+	db, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := db.Store("pony")
+
+	if value, err := s.Get("twilight"); err != nil || value != nil {
+		t.Error("Missing key has value: ", value, err)
+	}
+	s.Put("apple:jack", []byte("farm"))
+	s.Put("apple:bloom", []byte("crusader"))
+	s.Put("twilight", []byte("library"))
+	if err := s.PutJSON("rarity", map[string]int{"gems": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	s.ForEach("apple:", func(key string, value []byte) error {
+		keys = append(keys, key+"="+string(value))
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != "apple:bloom=crusader" || keys[1] != "apple:jack=farm" {
+		t.Error("Wrong prefix iteration: ", keys)
+	}
+
+	if value, _ := db.Store("other").Get("twilight"); value != nil {
+		t.Error("Namespaces are not separated")
+	}
+
+	//failed transaction is rolled back
+	err = s.Update(func(tx *Tx) error {
+		tx.Put("twilight", []byte("canterlot"))
+		tx.Delete("apple:jack")
+		return errors.New("nope")
+	})
+	if err == nil || err.Error() != "nope" {
+		t.Error("Transaction error not returned: ", err)
+	}
+	if value, _ := s.Get("twilight"); string(value) != "library" {
+		t.Error("Transaction was not rolled back: ", string(value))
+	}
+
+	s.Delete("apple:jack")
+	db.Close()
+	if _, err := s.Get("twilight"); err != ErrNotOpen {
+		t.Error("Closed store works: ", err)
+	}
+
+	//data survive reopening
+	db, err = Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s = db.Store("pony")
+
+	gems := map[string]int{}
+	if found, err := s.GetJSON("rarity", &gems); !found || err != nil || gems["gems"] != 3 {
+		t.Error("Wrong json value: ", found, err, gems)
+	}
+	if value, _ := s.Get("apple:jack"); value != nil {
+		t.Error("Deleted key exists: ", string(value))
+	}
+	if value, _ := s.Get("twilight"); string(value) != "library" {
+		t.Error("Value not persisted: ", string(value))
+	}
+}